@@ -0,0 +1,71 @@
+package ini
+
+import (
+	"reflect"
+
+	"github.com/pierrec/go-ini/internal/structs"
+)
+
+// arraySections returns the array sections with the given name, in order.
+func (ini *INI) arraySections(name string) []*iniSection {
+	var sections []*iniSection
+	for _, s := range ini.sections {
+		if s.Array && ini.sectionIs(s, name) {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// arrayElem returns an INI with the options of ini whose global section holds
+// the keys of the array section, for them to be decoded or encoded as a struct.
+// The other sections remain available to interpolation.
+func (ini *INI) arrayElem(section *iniSection) *INI {
+	elem := *ini
+	elem.global = iniSection{Name: section.Name, Array: true, Data: section.Data}
+	return &elem
+}
+
+// arrayElemSection returns the name of the first field of the array element
+// type t that would be decoded from or encoded into a section of its own,
+// or an empty string if there is none. Array sections only hold keys.
+// Types implementing iface handle the section themselves.
+func arrayElemSection(t, iface reflect.Type) string {
+	if implements(t, iface) {
+		return ""
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	root, err := structs.NewStruct(reflect.New(t).Interface(), iniTagID)
+	if err != nil {
+		return ""
+	}
+	return sectionField(root, iface, false)
+}
+
+// sectionField returns the name of the first field of s stored in a section
+// of its own. embedded is set when s is an embedded struct.
+func sectionField(s *structs.StructStruct, iface reflect.Type, embedded bool) string {
+	for _, field := range s.Fields() {
+		section, _, _ := getTagInfo(field.Tag(), field.Name())
+		if emb := field.Embedded(); emb != nil {
+			if embedded {
+				// Only the first level of embedded types is processed.
+				continue
+			}
+			if section != "" {
+				return field.FieldName()
+			}
+			if name := sectionField(emb, iface, true); name != "" {
+				return field.FieldName() + "." + name
+			}
+			continue
+		}
+		if section != "" || isSectionType(reflect.TypeOf(field.Value()), iface) ||
+			isSectionMap(field) || isSectionSlice(field) {
+			return field.FieldName()
+		}
+	}
+	return ""
+}
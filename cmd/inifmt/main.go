@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"unicode/utf8"
 
 	ini "github.com/pierrec/go-ini"
@@ -17,7 +18,7 @@ import (
 func main() {
 	var outName, comment, sliceSep string
 	flag.StringVar(&outName, "w", "", "write result to file instead of stdout")
-	flag.StringVar(&comment, "c", string(ini.DefaultComment), "comment character")
+	flag.StringVar(&comment, "c", string(ini.DefaultComment), "comment prefixes, separated by spaces")
 	flag.StringVar(&sliceSep, "sep", string(ini.DefaultSliceSeparator), "comment character")
 	var sensitive, merge bool
 	flag.BoolVar(&sensitive, "s", false, "make section and key names case sensitive")
@@ -55,8 +56,8 @@ func main() {
 	var options []ini.Option
 	sep, _ := utf8.DecodeRuneInString(sliceSep)
 	options = append(options, ini.SliceSeparator(sep))
-	if comment != "" {
-		options = append(options, ini.Comment(comment))
+	if prefixes := strings.Fields(comment); len(prefixes) > 0 {
+		options = append(options, ini.Comment(prefixes...))
 	}
 	if sensitive {
 		options = append(options, ini.CaseSensitive())
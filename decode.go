@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/pierrec/go-ini/internal/structs"
@@ -50,11 +51,159 @@ func Decode(r io.Reader, v interface{}) error {
 //  - bool
 //  - time.Time and time.Duration
 //  - slices of the above types
+// Fields whose key is missing are set to the value of their default struct
+// tag, if any, such as `default:"8080"`.
+// Other struct and pointer to struct fields are decoded from the section
+// named after the field, nested in the one of the enclosing struct using the
+// SectionSeparator, such as server.tls. Nil pointers are only allocated if
+// the section or a nested one exists. Fields implementing SectionUnmarshaler
+// decode the whole section themselves, if it exists.
+// Maps of structs are decoded from the subsections of their section, and
+// slices of structs from its [[name]] array sections with the ArraySections
+// option, whose elements only hold keys: they cannot have struct, map or
+// slice of struct fields.
+//
+// Fields with the required option, such as `ini:"port,server,required"`,
+// must have their key, or section for structs, maps and slices of structs,
+// defined. All the missing ones are reported as KeyErrors, along with the
+// unknown keys with the DisallowUnknownKeys option.
+//
+// Once decoded without error, the field values are checked against the rules
+// of their validate struct tag, such as `validate:"min=1,max=65535"`:
+//  - min=n and max=n bound numbers and durations, or the length of strings,
+//    slices and maps
+//  - oneof=a|b|c lists the allowed values
+//  - match=re requires the value to match the regular expression
+//  - nonempty rejects zero values and empty strings, slices and maps
+// All the failed rules are reported as RuleErrors. Otherwise the structs
+// implementing the Validator interface are validated, nested ones first.
+// The error returned by their Validate method is reported as a ValidationError.
 func (ini *INI) Decode(v interface{}) error {
-	return ini.decode("", v)
+	_, err := ini.DecodeMeta(v)
+	return err
 }
 
-func (ini *INI) decode(defaultSection string, v interface{}) error {
+// DecodeMeta decodes v as Decode does and describes the keys it used,
+// the ones it did not and the fields it left untouched.
+func (ini *INI) DecodeMeta(v interface{}) (MetaData, error) {
+	state := &decodeState{used: map[*iniItem]bool{}}
+	if err := ini.decodeAll(v, state); err != nil {
+		return MetaData{}, err
+	}
+	ini.undecoded(state)
+	if errs := ini.keyErrors(state); len(errs) > 0 {
+		return state.meta, errs
+	}
+	return state.meta, ini.validateAll(v)
+}
+
+// decodeState records the keys used and missing during Decode.
+type decodeState struct {
+	used    map[*iniItem]bool
+	missing KeyErrors
+	meta    MetaData
+	// Path to the struct being decoded, from the decoded value.
+	path []string
+	// Name of the array section being decoded, if any.
+	array string
+	// Reports the keys supplied elsewhere, such as by another layer,
+	// whose default tag must not be applied.
+	supplied func(section, key string) bool
+}
+
+// use records the items as used, such as by interpolation.
+func (s *decodeState) use(items ...*iniItem) {
+	if s == nil {
+		return
+	}
+	for _, item := range items {
+		s.used[item] = true
+	}
+}
+
+// decoded records the items as decoded into the field.
+func (s *decodeState) decoded(section string, field *structs.StructField, items ...*iniItem) {
+	s.decodedAt(section, s.fieldPath(field), items...)
+}
+
+// decodedAt records the items as decoded into the field with the given path.
+func (s *decodeState) decodedAt(section, path string, items ...*iniItem) {
+	s.use(items...)
+	for _, item := range items {
+		key := MetaKey{s.sectionName(section), item.Key, item.File, item.Line, path}
+		s.meta.Decoded = append(s.meta.Decoded, key)
+	}
+}
+
+// isSupplied returns whether or not the missing key is supplied elsewhere.
+// Array elements are decoded from a single source.
+func (s *decodeState) isSupplied(section, key string) bool {
+	return s.supplied != nil && s.array == "" && s.supplied(section, key)
+}
+
+func (s *decodeState) untouched(field *structs.StructField) {
+	s.untouchedAt(s.fieldPath(field))
+}
+
+func (s *decodeState) untouchedAt(path string) {
+	s.meta.Untouched = append(s.meta.Untouched, path)
+}
+
+func (s *decodeState) missingKey(section, key string) {
+	s.missing = append(s.missing, &KeyError{Section: s.sectionName(section), Key: key, Err: ErrMissingKey})
+}
+
+// sectionName returns the name of the section being decoded, the global
+// section of array elements being named after the array.
+func (s *decodeState) sectionName(section string) string {
+	if section == "" {
+		return s.array
+	}
+	return section
+}
+
+// fieldPath returns the path to the field from the decoded value.
+func (s *decodeState) fieldPath(field *structs.StructField) string {
+	return strings.Join(append(s.path[:len(s.path):len(s.path)], field.FieldName()), ".")
+}
+
+// decodeAll decodes v and records the keys used and missing into state.
+func (ini *INI) decodeAll(v interface{}, state *decodeState) error {
+	// Decode through a copy holding the state, so that ini is only read
+	// and may be decoded concurrently.
+	d := *ini
+	d.decoding = state
+	return d.decode("", v, false)
+}
+
+// undecoded records the keys of ini not used by the decoding.
+func (ini *INI) undecoded(state *decodeState) {
+	for _, s := range append([]*iniSection{&ini.global}, ini.sections...) {
+		for _, item := range s.Data {
+			if item == nil || state.used[item] {
+				continue
+			}
+			key := MetaKey{Section: s.Name, Key: item.Key, File: item.File, Line: item.Line}
+			state.meta.Undecoded = append(state.meta.Undecoded, key)
+		}
+	}
+}
+
+// keyErrors returns the missing keys and, with the DisallowUnknownKeys
+// option, the undecoded ones.
+func (ini *INI) keyErrors(state *decodeState) KeyErrors {
+	errs := state.missing
+	if ini.strict {
+		for _, k := range state.meta.Undecoded {
+			errs = append(errs, &KeyError{k.Section, k.Key, k.File, k.Line, ErrUnknownKey})
+		}
+	}
+	return errs
+}
+
+// decode decodes the section into v. embedded is set when v is an embedded
+// struct, whose own embedded types are ignored.
+func (ini *INI) decode(defaultSection string, v interface{}, embedded bool) error {
 	root, err := structs.NewStruct(v, iniTagID)
 	if err != nil {
 		return err
@@ -65,32 +214,278 @@ func (ini *INI) decode(defaultSection string, v interface{}) error {
 		if section == "" {
 			section = defaultSection
 		}
+		required := hasTagOption(field.Tag(), "required")
 
 		if emb := field.Embedded(); emb != nil {
-			if defaultSection != "" {
+			if embedded {
 				// Only process the first level of embedded types.
 				continue
 			}
-			if section == "" {
+			if section == "" && !ini.global.Array {
+				// Embedded types at the top level default to their own section,
+				// they otherwise share the one of the enclosing struct.
 				section = field.Name()
 			}
-			if err := ini.decode(section, emb); err != nil {
-				return fmt.Errorf("ini: decode: %s.%s: %v", section, key, err)
+			if err := ini.decode(section, emb, true); err != nil {
+				return fmt.Errorf("ini: decode: %s.%s: %w", section, key, err)
 			}
 			continue
 		}
 
-		keyValuePtr := ini.get(section, key)
-		if keyValuePtr == nil {
+		isStruct := isSectionType(reflect.TypeOf(field.Value()), sectionUnmarshalType)
+		if isStruct || isSectionMap(field) || isSectionSlice(field) {
+			nested := ini.nestedSection(section, key)
+			var err error
+			switch {
+			case required && !ini.hasNestedSections(nested):
+				ini.decoding.missingKey(nested, "")
+				ini.decoding.untouched(field)
+			case isStruct:
+				err = ini.decodeNested(nested, field)
+			case isSectionMap(field):
+				err = ini.decodeSectionMap(nested, field)
+			default:
+				err = ini.decodeArray(nested, field)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if ini.multiValues && isMultiValue(field) {
+			items := ini.getAll(section, key)
+			if items == nil {
+				// Not found.
+				if err := ini.notFound(section, key, field, required); err != nil {
+					return err
+				}
+				continue
+			}
+			ini.decoding.decoded(section, field, items...)
+			values := make([]string, len(items))
+			for i, item := range items {
+				v, err := ini.expand(section, item.Value, []string{qualifiedKey(section, key)})
+				if err != nil {
+					return fmt.Errorf("ini: decode: %s.%s: %w", section, key, err)
+				}
+				values[i] = v
+			}
+			if err := ini.setMultiValue(field, values); err != nil {
+				return fmt.Errorf("ini: decode: %s.%s: %w", section, key, err)
+			}
+			continue
+		}
+
+		item := ini.getItem(section, key)
+		if item == nil {
 			// Not found.
+			if err := ini.notFound(section, key, field, required); err != nil {
+				return err
+			}
 			continue
 		}
+		ini.decoding.decoded(section, field, item)
+		value, err := ini.expand(section, item.Value, []string{qualifiedKey(section, key)})
+		if err != nil {
+			return fmt.Errorf("ini: decode: %s.%s: %w", section, key, err)
+		}
+		if item.NoValue && reflect.ValueOf(field.Value()).Kind() == reflect.Bool {
+			// Bare keys are flags.
+			value = "true"
+		}
 
 		// The value was found. Try to convert it to the field type.
-		if err := field.Set(*keyValuePtr, ini.sliceSep, ini.mapkeySep); err != nil {
-			return fmt.Errorf("ini: decode: %s.%s: %v", section, key, err)
+		if err := field.Set(value, ini.sliceSep, ini.mapkeySep); err != nil {
+			return fmt.Errorf("ini: decode: %s.%s: %w", section, key, err)
+		}
+	}
+
+	return nil
+}
+
+// notFound handles a field whose key is missing: it is reported if the field
+// is required, otherwise the field is set to the value of its default tag, if any.
+func (ini *INI) notFound(section, key string, field *structs.StructField, required bool) error {
+	state := ini.decoding
+	if required {
+		state.missingKey(section, key)
+	} else if value, ok := field.Tag().Lookup(defaultTagID); ok && !state.isSupplied(section, key) {
+		if err := field.Set(value, ini.sliceSep, ini.mapkeySep); err != nil {
+			return fmt.Errorf("ini: decode: %s.%s: default %q: %w", section, key, value, err)
+		}
+		state.meta.Defaulted = append(state.meta.Defaulted, state.fieldPath(field))
+		return nil
+	}
+	state.untouched(field)
+	return nil
+}
+
+// isDefault returns whether or not the field holds the value of its default tag.
+func (ini *INI) isDefault(field *structs.StructField) bool {
+	value, ok := field.Tag().Lookup(defaultTagID)
+	if !ok {
+		return false
+	}
+	v := reflect.New(reflect.TypeOf(field.Value())).Elem()
+	if err := structs.Set(v, value, ini.sliceSep, ini.mapkeySep); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(v.Interface(), field.Value())
+}
+
+// isMultiValue returns whether or not the field receives all the values
+// of a repeated key, which is the case for slices that cannot be decoded
+// from text.
+func isMultiValue(field *structs.StructField) bool {
+	v := reflect.ValueOf(field.PtrValue())
+	return v.Elem().Kind() == reflect.Slice && !v.Type().Implements(textUnmarshalType)
+}
+
+// decodeNested decodes the section into the struct field, allocating it
+// if it is a nil pointer and the section or a nested one exists.
+func (ini *INI) decodeNested(section string, field *structs.StructField) error {
+	v := reflect.ValueOf(field.PtrValue()).Elem()
+	if implements(v.Type(), sectionUnmarshalType) && ini.getSection(section) == nil {
+		// Only decode existing sections, nested ones being irrelevant.
+		ini.decoding.untouched(field)
+		return nil
+	}
+	if v.Kind() != reflect.Ptr {
+		v = v.Addr()
+	} else if v.IsNil() {
+		if !ini.hasNestedSections(section) {
+			ini.decoding.untouched(field)
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	return ini.decodeElem(section, field.FieldName(), v)
+}
+
+// decodeElem decodes the section into v, a pointer to a struct found
+// at the given path from the struct being decoded.
+func (ini *INI) decodeElem(section, path string, v reflect.Value) error {
+	state := ini.decoding
+	state.path = append(state.path, path)
+	defer func() { state.path = state.path[:len(state.path)-1] }()
+	if u, ok := v.Interface().(SectionUnmarshaler); ok {
+		return ini.unmarshalSection(section, u)
+	}
+	return ini.decode(section, v.Interface(), false)
+}
+
+// isSectionMap returns whether or not the field is a map of structs,
+// or pointers to structs, keyed by strings.
+func isSectionMap(field *structs.StructField) bool {
+	t := reflect.TypeOf(field.Value())
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && structs.IsStruct(t.Elem())
+}
+
+// isSectionSlice returns whether or not the field is a slice of structs,
+// or pointers to structs.
+func isSectionSlice(field *structs.StructField) bool {
+	t := reflect.TypeOf(field.Value())
+	return t.Kind() == reflect.Slice && structs.IsStruct(t.Elem())
+}
+
+// decodeSectionMap decodes the subsections of the given section
+// into the map field, keyed by subsection.
+func (ini *INI) decodeSectionMap(section string, field *structs.StructField) error {
+	subs := ini.Subsections(section)
+	if len(subs) == 0 {
+		ini.decoding.untouched(field)
+		return nil
+	}
+	m := reflect.ValueOf(field.PtrValue()).Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+	elemType := m.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	unmarshal := implements(elemType, sectionUnmarshalType)
+	for _, sub := range subs {
+		path := fmt.Sprintf("%s[%s]", field.FieldName(), sub)
+		nested := section + ini.sectionSep + sub
+		if unmarshal && ini.getSection(nested) == nil {
+			// Only decode existing sections, as decodeNested does.
+			ini.decoding.untouchedAt(ini.decoding.fieldPath(field) + "[" + sub + "]")
+			continue
+		}
+		key := reflect.ValueOf(sub).Convert(m.Type().Key())
+		// Start from the existing value, if any.
+		elem := reflect.New(elemType)
+		if v := m.MapIndex(key); v.IsValid() && !(isPtr && v.IsNil()) {
+			if isPtr {
+				elem = v
+			} else {
+				elem.Elem().Set(v)
+			}
+		}
+		if err := ini.decodeElem(nested, path, elem); err != nil {
+			return err
+		}
+		if isPtr {
+			m.SetMapIndex(key, elem)
+		} else {
+			m.SetMapIndex(key, elem.Elem())
 		}
 	}
+	return nil
+}
 
+// decodeArray decodes the array sections with the given name
+// into the slice field, in order.
+func (ini *INI) decodeArray(section string, field *structs.StructField) error {
+	slice := reflect.ValueOf(field.PtrValue()).Elem()
+	if name := arrayElemSection(slice.Type().Elem(), sectionUnmarshalType); name != "" {
+		return fmt.Errorf("ini: decode: %s: %s: nested sections are not supported in array elements", section, name)
+	}
+	sections := ini.arraySections(section)
+	if len(sections) == 0 {
+		ini.decoding.untouched(field)
+		return nil
+	}
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	values := reflect.MakeSlice(slice.Type(), len(sections), len(sections))
+	for i, s := range sections {
+		elem := reflect.New(elemType)
+		ini.decoding.array = fmt.Sprintf("%s[%d]", section, i)
+		path := fmt.Sprintf("%s[%d]", field.FieldName(), i)
+		err := ini.arrayElem(s).decodeElem("", path, elem)
+		ini.decoding.array = ""
+		if err != nil {
+			return fmt.Errorf("ini: decode: %s[%d]: %w", section, i, err)
+		}
+		if isPtr {
+			values.Index(i).Set(elem)
+		} else {
+			values.Index(i).Set(elem.Elem())
+		}
+	}
+	slice.Set(values)
+	return nil
+}
+
+// setMultiValue sets the field to a slice with the items of all the values,
+// each one being split on the SliceSeparator.
+func (ini *INI) setMultiValue(field *structs.StructField, values []string) error {
+	value := reflect.ValueOf(field.PtrValue()).Elem()
+	slice := reflect.MakeSlice(value.Type(), 0, len(values))
+	for _, s := range values {
+		items := reflect.New(value.Type()).Elem()
+		if err := structs.Set(items, s, ini.sliceSep, ini.mapkeySep); err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+		slice = reflect.AppendSlice(slice, items)
+	}
+	value.Set(slice)
 	return nil
 }
@@ -10,12 +10,16 @@ implementation follows these rules:
  - leading whitespace for key values are ignored
  - all characters from the first non whitespace to the end of the line are
  accepted for a value, unless the value is single or double quoted
+ - double quoted values may contain the escape sequences \n, \t, \r, \\, \",
+ \xNN and \uXXXX, single quoted values are taken literally
  - anything after a quoted value is ignored
  - section and key names are not case sensitive by default
  - in case of conflicting key names, only the last one is used
  - in case of conflicting section names, only the last one is considered
  by default. However, if specified during initialization, the keys of
  conflicting sections can be merged.
+ - [[name]] headers are parsed as repeatable array sections only with the
+ ArraySections option, otherwise they define the section [name
 
 Behaviour of INI processing can be modified using struct tags. The struct tags
 are defined by the "ini" keyword. The struct tags format is:
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,11 +31,16 @@ func Encode(w io.Writer, v interface{}) error {
 
 // Encode sets Ini sections and keys according to the values defined in v.
 // v must be a pointer to a struct.
+// Nested structs are encoded into nested sections, as described in Decode.
+// Fields implementing SectionMarshaler encode the whole section themselves.
+// With the OmitDefaults option, fields holding their default value are left out.
 func (ini *INI) Encode(v interface{}) error {
-	return ini.encode("", v)
+	return ini.encode("", v, false)
 }
 
-func (ini *INI) encode(defaultSection string, v interface{}) error {
+// encode encodes v into the section. embedded is set when v is an embedded
+// struct, whose own embedded types are ignored.
+func (ini *INI) encode(defaultSection string, v interface{}, embedded bool) error {
 	root, err := structs.NewStruct(v, iniTagID)
 	if err != nil {
 		return err
@@ -47,25 +53,73 @@ func (ini *INI) encode(defaultSection string, v interface{}) error {
 		}
 
 		if emb := field.Embedded(); emb != nil {
-			if defaultSection != "" {
+			if embedded {
 				// Only process the first level of embedded types.
 				continue
 			}
-			if section == "" {
+			if section == "" && !ini.global.Array {
+				// Embedded types at the top level default to their own section,
+				// they otherwise share the one of the enclosing struct.
 				section = field.Name()
 			}
-			if err := ini.encode(section, emb); err != nil {
-				return fmt.Errorf("ini: encode: %s.%s: %v", section, key, err)
+			if err := ini.encode(section, emb, true); err != nil {
+				return fmt.Errorf("ini: encode: %s.%s: %w", section, key, err)
 			}
 			continue
 		}
 
-		mvalue, err := structs.MarshalValue(field.Value(), ini.sliceSep, ini.mapkeySep)
-		if err != nil {
-			return fmt.Errorf("ini: encode: %s.%s: %v", section, key, err)
+		if isSectionType(reflect.TypeOf(field.Value()), sectionMarshalType) {
+			// Nested struct.
+			v := reflect.ValueOf(field.PtrValue()).Elem()
+			if v.Kind() != reflect.Ptr {
+				v = v.Addr()
+			} else if v.IsNil() {
+				continue
+			}
+			if err := ini.encodeElem(ini.nestedSection(section, key), v); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isSectionMap(field) {
+			if err := ini.encodeSectionMap(ini.nestedSection(section, key), field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isSectionSlice(field) {
+			if err := ini.encodeArray(ini.nestedSection(section, key), field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if ini.omitDefaults && ini.isDefault(field) {
+			ini.Del(section, key)
+			continue
+		}
+
+		if ini.multiValues && isMultiValue(field) {
+			// One key per slice item.
+			ini.Del(section, key)
+			value := reflect.ValueOf(field.Value())
+			for i := 0; i < value.Len(); i++ {
+				mvalue, err := structs.MarshalValue(value.Index(i).Interface(), ini.sliceSep, ini.mapkeySep)
+				if err != nil {
+					return fmt.Errorf("ini: encode: %s.%s: %w", section, key, err)
+				}
+				ini.Add(section, key, fmt.Sprintf("%v", mvalue))
+			}
+		} else {
+			mvalue, err := structs.MarshalValue(field.Value(), ini.sliceSep, ini.mapkeySep)
+			if err != nil {
+				return fmt.Errorf("ini: encode: %s.%s: %w", section, key, err)
+			}
+			keyValue := fmt.Sprintf("%v", mvalue)
+			ini.Set(section, key, keyValue)
 		}
-		keyValue := fmt.Sprintf("%v", mvalue)
-		ini.Set(section, key, keyValue)
 
 		if isLastKey {
 			ini.Set(section, "", "")
@@ -75,12 +129,85 @@ func (ini *INI) encode(defaultSection string, v interface{}) error {
 	return nil
 }
 
+// encodeElem encodes v, a pointer to a struct or a SectionMarshaler,
+// into the section.
+func (ini *INI) encodeElem(section string, v reflect.Value) error {
+	if m, ok := v.Interface().(SectionMarshaler); ok {
+		return ini.marshalSection(section, m)
+	}
+	return ini.encode(section, v.Interface(), false)
+}
+
+// encodeSectionMap encodes the map field into the subsections of the given
+// section, in the order of the map keys.
+func (ini *INI) encodeSectionMap(section string, field *structs.StructField) error {
+	m := reflect.ValueOf(field.Value())
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, key := range keys {
+		v := m.MapIndex(key)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+		} else {
+			// Get an addressable copy.
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p
+		}
+		if err := ini.encodeElem(ini.nestedSection(section, key.String()), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeArray replaces the array sections with the given name
+// by the items of the slice field.
+func (ini *INI) encodeArray(section string, field *structs.StructField) error {
+	slice := reflect.ValueOf(field.Value())
+	if !ini.arrays {
+		if slice.Len() == 0 {
+			return nil
+		}
+		return fmt.Errorf("ini: encode: %s: slices of structs require the ArraySections option", section)
+	}
+	if name := arrayElemSection(slice.Type().Elem(), sectionMarshalType); name != "" {
+		return fmt.Errorf("ini: encode: %s: %s: nested sections are not supported in array elements", section, name)
+	}
+	for _, s := range ini.arraySections(section) {
+		ini.unlinkSection(s)
+	}
+	for i := 0; i < slice.Len(); i++ {
+		v := slice.Index(i)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+		} else {
+			v = v.Addr()
+		}
+		elem := ini.arrayElem(&iniSection{})
+		if err := elem.encodeElem("", v); err != nil {
+			return fmt.Errorf("ini: encode: %s[%d]: %w", section, i, err)
+		}
+		ini.sections = append(ini.sections, &iniSection{
+			Name:  section,
+			Array: true,
+			Data:  elem.global.Data,
+		})
+	}
+	return nil
+}
+
 // Figure out the key and section to look for in Ini.
 // Otherwise, if it is not specified, the field name is used as the key.
 // A struct tag may contain 3 entries:
 //  - the key name (defaults to the field name)
 //  - the section name (defaults to the global section)
 //  - whether the key is the last of a block, which introduces a newline
+// followed by options, such as required.
 func getTagInfo(tags reflect.StructTag, defaultKey string) (section, key string, isLastKey bool) {
 	tag := tags.Get(iniTagID)
 	if tag == "" {
@@ -103,3 +230,15 @@ func getTagInfo(tags reflect.StructTag, defaultKey string) (section, key string,
 	}
 	return
 }
+
+// hasTagOption returns whether or not the struct tag lists the given option
+// after the key and section names.
+func hasTagOption(tags reflect.StructTag, option string) bool {
+	lst := strings.Split(tags.Get(iniTagID), ",")
+	for i := 2; i < len(lst); i++ {
+		if lst[i] == option {
+			return true
+		}
+	}
+	return false
+}
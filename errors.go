@@ -0,0 +1,198 @@
+package ini
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of ParseError, to be used with errors.Is.
+var (
+	ErrMissingBracket    = errors.New("missing ]")
+	ErrMissingEquals     = errors.New("missing =")
+	ErrEmptySectionName  = errors.New("empty section name")
+	ErrUnterminatedQuote = errors.New("string literal not terminated")
+	ErrIncludeCycle      = errors.New("include cycle")
+	ErrIncludeDepth      = errors.New("too many nested includes")
+	ErrMissingParent     = errors.New("missing parent section")
+	ErrInheritanceCycle  = errors.New("section inheritance cycle")
+)
+
+// Kinds of KeyError, to be used with errors.Is.
+var (
+	ErrMissingKey = errors.New("missing required key")
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// ParseError describes a malformed line encountered by ReadFrom.
+type ParseError struct {
+	// File is the name of the source, if known.
+	File string
+	// Line and Column locate the error, both starting at 1.
+	Line   int
+	Column int
+	// Text is the offending line, without its line ending.
+	Text string
+	// Err is the kind of error, such as ErrMissingBracket.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("ini: %d:%d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("ini: %s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the kind of error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Snippet returns the offending line followed by a line with
+// a caret pointing at the error column.
+func (e *ParseError) Snippet() string {
+	// Keep tabs so that the caret lines up with the text.
+	var pad strings.Builder
+	for i := 0; i < e.Column-1 && i < len(e.Text); i++ {
+		if e.Text[i] == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	for i := len(e.Text); i < e.Column-1; i++ {
+		pad.WriteByte(' ')
+	}
+	return fmt.Sprintf("%s\n%s^", e.Text, pad.String())
+}
+
+// ParseErrors lists all the malformed lines found by ReadFrom
+// when the ContinueOnError option is set.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	lst := make([]string, len(e))
+	for i, err := range e {
+		lst[i] = err.Error()
+	}
+	return strings.Join(lst, "\n")
+}
+
+// Unwrap returns the list of errors.
+func (e ParseErrors) Unwrap() []error {
+	lst := make([]error, len(e))
+	for i, err := range e {
+		lst[i] = err
+	}
+	return lst
+}
+
+// KeyError describes a required key missing from the source, or a key in
+// the source no struct field is decoded from with the DisallowUnknownKeys
+// option.
+type KeyError struct {
+	// Section and Key name the key. Key is empty for a missing section.
+	Section string
+	Key     string
+	// File and Line locate the unknown key, if known.
+	File string
+	Line int
+	// Err is the kind of error, such as ErrMissingKey.
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	name := e.Section
+	if e.Key != "" {
+		name = qualifiedKey(e.Section, e.Key)
+	}
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("ini: decode: %s: %v", name, e.Err)
+	case e.File == "":
+		return fmt.Sprintf("ini: decode: %d: %s: %v", e.Line, name, e.Err)
+	}
+	return fmt.Sprintf("ini: decode: %s:%d: %s: %v", e.File, e.Line, name, e.Err)
+}
+
+// Unwrap returns the kind of error.
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// KeyErrors lists all the missing and unknown keys found by Decode.
+type KeyErrors []*KeyError
+
+func (e KeyErrors) Error() string {
+	lst := make([]string, len(e))
+	for i, err := range e {
+		lst[i] = err.Error()
+	}
+	return strings.Join(lst, "\n")
+}
+
+// Unwrap returns the list of errors.
+func (e KeyErrors) Unwrap() []error {
+	lst := make([]error, len(e))
+	for i, err := range e {
+		lst[i] = err
+	}
+	return lst
+}
+
+// ValidationError describes a struct whose Validate method failed after Decode.
+type ValidationError struct {
+	// Section is the one the struct was decoded from, empty for the global one.
+	Section string
+	// Err is the error returned by Validate.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Section == "" {
+		return fmt.Sprintf("ini: decode: %v", e.Err)
+	}
+	return fmt.Sprintf("ini: decode: %s: %v", e.Section, e.Err)
+}
+
+// Unwrap returns the error returned by Validate.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// RuleError describes a field whose value does not satisfy
+// a rule of its validate struct tag.
+type RuleError struct {
+	// Section and Key name the key the field is decoded from.
+	Section string
+	Key     string
+	// Field is the path of the field, such as Server.Port.
+	Field string
+	// Rule is the failed rule, such as max=65535.
+	Rule string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("ini: decode: %s: %s does not satisfy %s", qualifiedKey(e.Section, e.Key), e.Field, e.Rule)
+}
+
+// RuleErrors lists all the rules failed during Decode.
+type RuleErrors []*RuleError
+
+func (e RuleErrors) Error() string {
+	lst := make([]string, len(e))
+	for i, err := range e {
+		lst[i] = err.Error()
+	}
+	return strings.Join(lst, "\n")
+}
+
+// Unwrap returns the list of errors.
+func (e RuleErrors) Unwrap() []error {
+	lst := make([]error, len(e))
+	for i, err := range e {
+		lst[i] = err
+	}
+	return lst
+}
@@ -0,0 +1,141 @@
+package ini
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Include directives recognized with the Includes option.
+const (
+	includeDirective    = "!include"
+	includeDirDirective = "!includedir"
+)
+
+// includeExts lists the extensions of the files read by !includedir.
+var includeExts = []string{".ini", ".cnf", ".conf"}
+
+// includeFile is a source being read by ReadFrom.
+type includeFile struct {
+	r       *bufio.Reader
+	f       io.Closer
+	name    string
+	lineNum int
+	// Files being included, starting with the top level source.
+	chain []string
+}
+
+func (in *includeFile) close() {
+	if in.f != nil {
+		in.f.Close()
+	}
+}
+
+// dir returns the directory the paths included by the file are relative to.
+func (in *includeFile) dir() string {
+	if in.f == nil && !fs.ValidPath(in.name) {
+		// Top level source not from the file system.
+		return "."
+	}
+	return path.Dir(in.name)
+}
+
+// includeDirective returns the path of an include directive and whether or
+// not it refers to a directory, along with its position in the line.
+func (ini *INI) includeDirective(line []byte) (name string, dir bool, pos int, ok bool) {
+	if ini.fsys == nil {
+		return
+	}
+	var rest []byte
+	switch {
+	case bytes.HasPrefix(line, []byte(includeDirDirective)):
+		rest, dir = line[len(includeDirDirective):], true
+	case bytes.HasPrefix(line, []byte(includeDirective)):
+		rest = line[len(includeDirective):]
+	default:
+		return
+	}
+	if len(rest) == 0 || !unicode.IsSpace(rune(rest[0])) {
+		return
+	}
+	p := bytes.TrimSpace(rest)
+	pos = len(line) - len(bytes.TrimLeftFunc(rest, unicode.IsSpace))
+	return string(p), dir, pos, true
+}
+
+// openIncludes opens the file, or the files of the directory, included by in.
+// With dir unset, name may still refer to a directory with the IncludeKey option.
+func (ini *INI) openIncludes(in *includeFile, name string, dir, stat bool) ([]*includeFile, error) {
+	if len(in.chain) > ini.includeDepth {
+		return nil, ErrIncludeDepth
+	}
+	if path.IsAbs(name) {
+		// Absolute paths are relative to the root of the file system.
+		name = path.Clean(strings.TrimLeft(name, "/"))
+	} else {
+		name = path.Join(in.dir(), name)
+	}
+	if stat {
+		fi, err := fs.Stat(ini.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		dir = fi.IsDir()
+	}
+
+	names := []string{name}
+	if dir {
+		entries, err := fs.ReadDir(ini.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, e := range entries {
+			if !e.IsDir() && hasIncludeExt(e.Name()) {
+				names = append(names, path.Join(name, e.Name()))
+			}
+		}
+	}
+
+	files := make([]*includeFile, 0, len(names))
+	for _, name := range names {
+		for _, n := range in.chain {
+			if n == name {
+				closeIncludes(files)
+				return nil, ErrIncludeCycle
+			}
+		}
+		f, err := ini.fsys.Open(name)
+		if err != nil {
+			closeIncludes(files)
+			return nil, err
+		}
+		files = append(files, &includeFile{
+			r:     bufio.NewReader(f),
+			f:     f,
+			name:  name,
+			chain: append(in.chain[:len(in.chain):len(in.chain)], name),
+		})
+	}
+	return files, nil
+}
+
+func closeIncludes(files []*includeFile) {
+	for _, in := range files {
+		in.close()
+	}
+}
+
+func hasIncludeExt(name string) bool {
+	ext := path.Ext(name)
+	for _, e := range includeExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
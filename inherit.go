@@ -0,0 +1,51 @@
+package ini
+
+// inheritance records a section header with a parent for it to be checked
+// once all the sections are read.
+type inheritance struct {
+	section *iniSection
+	// err locates the parent in the header.
+	err *ParseError
+}
+
+// checkInheritance returns the errors for the sections whose parent
+// does not exist or which inherit from themselves.
+func (ini *INI) checkInheritance(lst []inheritance) []*ParseError {
+	var errs []*ParseError
+	for _, h := range lst {
+		if ini.getSection(h.section.Name) != h.section || h.section.Parent == "" {
+			// Section redefined.
+			continue
+		}
+		lineage := []*iniSection{h.section}
+		for s := h.section; s.Parent != ""; {
+			if s = ini.getSection(s.Parent); s == nil {
+				if len(lineage) == 1 {
+					h.err.Err = ErrMissingParent
+					errs = append(errs, h.err)
+				}
+				break
+			}
+			if s == h.section {
+				h.err.Err = ErrInheritanceCycle
+				errs = append(errs, h.err)
+				break
+			}
+			if hasSection(lineage, s) {
+				// Cycle not involving this section.
+				break
+			}
+			lineage = append(lineage, s)
+		}
+	}
+	return errs
+}
+
+func hasSection(lst []*iniSection, section *iniSection) bool {
+	for _, s := range lst {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
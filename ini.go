@@ -2,16 +2,37 @@ package ini
 
 import (
 	"io"
+	"io/fs"
 	"strings"
 )
 
 const (
 	// DefaultComment is the default value used to prefix comments.
 	DefaultComment = ";"
+	// DefaultDelimiter is the default key/value delimiter.
+	DefaultDelimiter = "="
 	// DefaultSliceSeparator is the default slice separator used to decode and encode slices.
 	DefaultSliceSeparator = ','
 	// DefaultMapKeySeparator is the default map key separator used to decode and encode slices.
 	DefaultMapKeySeparator = ':'
+	// DefaultSectionSeparator is the default separator of nested section names.
+	DefaultSectionSeparator = "."
+	// DefaultIncludeDepth is the default maximum number of nested includes.
+	DefaultIncludeDepth = 10
+)
+
+// ContinuationStyle defines how values spanning several lines are represented.
+type ContinuationStyle int
+
+const (
+	// NoContinuation reads every line independently.
+	NoContinuation ContinuationStyle = iota
+	// BackslashContinuation joins a line ending with a backslash
+	// with the next one, without its leading whitespaces.
+	BackslashContinuation
+	// IndentContinuation appends indented lines following a key to its value,
+	// separated by a newline, as Python's configparser does.
+	IndentContinuation
 )
 
 // DefaultOptions lists the Options for the Encode and Decode functions to use.
@@ -24,22 +45,60 @@ const (
 	mergeSectionsWithLastComments
 )
 
+// defaultTagID is the struct tag holding the default value of a field.
+const defaultTagID = "default"
+
 var _ io.ReaderFrom = (*INI)(nil)
 var _ io.WriterTo = (*INI)(nil)
 
 // INI represents the content of an ini source.
 type INI struct {
-	comment         []byte
+	comments        [][]byte
+	delimiters      [][]byte
+	writeDelimiter  string
 	isCaseSensitive bool
 	mergeSections   int
+	continueOnError bool
+	preserve        bool
+	inlineComments  bool
+	bareKeys        bool
+	multiValues     bool
+	interpolation   int
+	lookupEnv       func(string) (string, bool)
+	fsys            fs.FS
+	includeKey      string
+	includeDepth    int
+	hasDefaults     bool
+	defaultSection  string
+	inheritance     bool
+	subsections     bool
+	arrays          bool
+	sectionSep      string
+	omitDefaults    bool
+	strict          bool
+	continuation    ContinuationStyle
+	wrapWidth       int
 	sliceSep        rune
 	mapkeySep       rune
 
+	// Keys used and missing during Decode, only set on the copy being decoded.
+	decoding *decodeState
+	// Layers resolving the references, only set on the copies made by Layers.
+	layers *Layers
+
 	// This is the global section, without a name.
 	global iniSection
 
 	// Named sections.
 	sections []*iniSection
+
+	// Line ending, lines following the last section, reopened section
+	// headers and whether or not the last line had no line ending
+	// with the PreserveFormatting option.
+	eol       string
+	trailer   []string
+	fragments []*fragment
+	noEOL     bool
 }
 
 // New instantiates a new Ini type ready for parsing.
@@ -51,8 +110,11 @@ func New(options ...Option) (*INI, error) {
 		}
 	}
 
-	if len(ini.comment) == 0 {
-		ini.comment = []byte(DefaultComment)
+	if len(ini.comments) == 0 {
+		ini.comments = [][]byte{[]byte(DefaultComment)}
+	}
+	if len(ini.delimiters) == 0 {
+		ini.delimiters = [][]byte{[]byte(DefaultDelimiter)}
 	}
 	if ini.sliceSep == 0 {
 		ini.sliceSep = DefaultSliceSeparator
@@ -60,6 +122,12 @@ func New(options ...Option) (*INI, error) {
 	if ini.mapkeySep == 0 {
 		ini.mapkeySep = DefaultMapKeySeparator
 	}
+	if ini.sectionSep == "" {
+		ini.sectionSep = DefaultSectionSeparator
+	}
+	if ini.includeDepth <= 0 {
+		ini.includeDepth = DefaultIncludeDepth
+	}
 
 	return ini, nil
 }
@@ -69,6 +137,10 @@ func New(options ...Option) (*INI, error) {
 func (ini *INI) Reset() {
 	ini.global = iniSection{}
 	ini.sections = nil
+	ini.eol = ""
+	ini.trailer = nil
+	ini.fragments = nil
+	ini.noEOL = false
 }
 
 func (ini *INI) getSection(section string) *iniSection {
@@ -76,9 +148,8 @@ func (ini *INI) getSection(section string) *iniSection {
 		return &ini.global
 	}
 
-	section = ident(ini.isCaseSensitive, section)
 	for _, s := range ini.sections {
-		if ident(ini.isCaseSensitive, s.Name) == section {
+		if ini.sectionIs(s, section) {
 			return s
 		}
 	}
@@ -86,23 +157,32 @@ func (ini *INI) getSection(section string) *iniSection {
 }
 
 func (ini *INI) addSection(section string) *iniSection {
-	sec := &iniSection{Name: section}
+	sec := ini.newSection(section)
 	ini.sections = append(ini.sections, sec)
 	return sec
 }
 
 func (ini *INI) rmSection(section string) bool {
-	section = ident(ini.isCaseSensitive, section)
+	for _, s := range ini.sections {
+		if ini.sectionIs(s, section) {
+			ini.unlinkSection(s)
+			return true
+		}
+	}
+	return false
+}
+
+// unlinkSection removes the section from the list of sections.
+func (ini *INI) unlinkSection(section *iniSection) {
 	for i, s := range ini.sections {
-		if ident(ini.isCaseSensitive, s.Name) == section {
+		if s == section {
 			n := len(ini.sections) - 1
 			copy(ini.sections[i:], ini.sections[i+1:])
 			ini.sections[n] = nil
 			ini.sections = ini.sections[:n]
-			return true
+			return
 		}
 	}
-	return false
 }
 
 // Has returns whether or not the section (if the key is empty) or
@@ -116,15 +196,120 @@ func (ini *INI) Has(section, key string) bool {
 
 // Get fetches the key value in the given section.
 // If the section or the key is not found an empty string is returned.
+// With the Interpolate, PythonInterpolation or ExpandEnv options, references
+// are expanded and the raw value is returned if they cannot be.
+// Use Expand to get the error.
 func (ini *INI) Get(section, key string) string {
-	if v := ini.get(section, key); v != nil {
+	v := ini.get(section, key)
+	if v == nil {
+		return ""
+	}
+	s, err := ini.expand(section, *v, []string{qualifiedKey(section, key)})
+	if err != nil {
 		return *v
 	}
-	return ""
+	return s
 }
 
 func (ini *INI) get(section, key string) *string {
-	return ini.getSection(section).get(key, ini.isCaseSensitive)
+	if item := ini.getItem(section, key); item != nil {
+		return &item.Value
+	}
+	return nil
+}
+
+func (ini *INI) getItem(section, key string) *iniItem {
+	sec := ini.getSection(section)
+	if item := sec.getItem(key, ini.isCaseSensitive); item != nil || sec == nil {
+		return item
+	}
+	for _, s := range ini.lineage(sec)[1:] {
+		if item := s.getItem(key, ini.isCaseSensitive); item != nil {
+			return item
+		}
+	}
+	return nil
+}
+
+// lineage returns the section followed by the ones it inherits keys from,
+// with the SectionInheritance and DefaultSection options.
+func (ini *INI) lineage(section *iniSection) []*iniSection {
+	lst := []*iniSection{section}
+	for s := section; ini.inheritance && s.Parent != ""; {
+		if s = ini.getSection(s.Parent); s == nil || hasSection(lst, s) {
+			// Missing parent or cycle, reported by ReadFrom.
+			break
+		}
+		lst = append(lst, s)
+	}
+	if d := ini.defaults(section); d != nil && !hasSection(lst, d) {
+		lst = append(lst, d)
+	}
+	return lst
+}
+
+// defaults returns the section supplying the default keys of the given one
+// with the DefaultSection option, if any.
+func (ini *INI) defaults(section *iniSection) *iniSection {
+	if !ini.hasDefaults || section == nil || section == &ini.global {
+		return nil
+	}
+	if d := ini.getSection(ini.defaultSection); d != section {
+		return d
+	}
+	return nil
+}
+
+// Source returns the name of the file the key was read from, if known,
+// and its line number. The line number is 0 for keys not read by ReadFrom.
+func (ini *INI) Source(section, key string) (file string, line int) {
+	if item := ini.getItem(section, key); item != nil {
+		return item.File, item.Line
+	}
+	return "", 0
+}
+
+// Parent returns the name of the section the given one inherits keys from
+// with the SectionInheritance option, if any.
+func (ini *INI) Parent(section string) string {
+	if s := ini.getSection(section); s != nil {
+		return s.Parent
+	}
+	return ""
+}
+
+// IsBare returns whether or not the key exists without any value
+// nor delimiter, as opposed to a key with an empty value.
+func (ini *INI) IsBare(section, key string) bool {
+	item := ini.getItem(section, key)
+	return item != nil && item.NoValue
+}
+
+// GetAll fetches all the values of the key in the given section, in order.
+// Only the last one is kept unless the MultiValues option is set.
+func (ini *INI) GetAll(section, key string) []string {
+	items := ini.getAll(section, key)
+	if len(items) == 0 {
+		return nil
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = item.Value
+	}
+	return values
+}
+
+func (ini *INI) getAll(section, key string) []*iniItem {
+	sec := ini.getSection(section)
+	if sec == nil {
+		return nil
+	}
+	for _, s := range ini.lineage(sec) {
+		if items := s.getAll(key, ini.isCaseSensitive); len(items) > 0 {
+			return items
+		}
+	}
+	return nil
 }
 
 // GetComments gets the comments for the given section or key.
@@ -146,16 +331,29 @@ func (ini *INI) GetComments(section, key string) []string {
 	return nil
 }
 
+// GetInlineComment gets the comment on the same line as the given key.
+func (ini *INI) GetInlineComment(section, key string) string {
+	if item := ini.getItem(section, key); item != nil {
+		return item.InlineComment
+	}
+	return ""
+}
+
 // Set adds the key with its value to the given section.
 // If the section does not exist it is created.
 // Setting an empty key adds a newline for the next keys.
 func (ini *INI) Set(section, key, value string) {
+	ini.set(section, key, value, false)
+}
+
+func (ini *INI) set(section, key, value string, noValue bool) {
 	sec := ini.getSection(section)
 	if sec == nil {
 		sec = ini.addSection(section)
-	} else {
+	} else if sec.Name != section {
 		// The section name may be different.
 		sec.Name = section
+		sec.Modified = true
 	}
 
 	if key == "" {
@@ -168,14 +366,36 @@ func (ini *INI) Set(section, key, value string) {
 
 	if item := sec.getItem(key, ini.isCaseSensitive); item != nil {
 		// The key does exist.
-		item.Key = key
-		item.Value = value
+		if item.Key != key || item.Value != value || item.NoValue != noValue {
+			item.Key = key
+			item.Value = value
+			item.NoValue = noValue
+			item.Modified = true
+		}
 		return
 	}
 	// The key does not exist.
+	sec.Data = append(sec.Data, &iniItem{Key: key, Value: value, NoValue: noValue})
+}
+
+// Add adds the key with its value to the given section, even if the key
+// already exists. Use it with the MultiValues option.
+// If the section does not exist it is created.
+func (ini *INI) Add(section, key, value string) {
+	sec := ini.getSection(section)
+	if sec == nil {
+		sec = ini.addSection(section)
+	}
 	sec.Data = append(sec.Data, &iniItem{Key: key, Value: value})
 }
 
+// SetBare adds the key without any value to the given section,
+// or removes the value of an existing key.
+// If the section does not exist it is created.
+func (ini *INI) SetBare(section, key string) {
+	ini.set(section, key, "", true)
+}
+
 // SetComments sets the comments for the given section or key.
 // Use an empty key to set the section comments.
 func (ini *INI) SetComments(section, key string, comments ...string) {
@@ -186,19 +406,37 @@ func (ini *INI) SetComments(section, key string, comments ...string) {
 			sec = ini.addSection(section)
 		}
 		sec.Comments = comments
+		sec.Prefixes = nil
+		sec.Modified = true
 		return
 	}
 
 	if item := sec.getItem(key, ini.isCaseSensitive); item != nil {
 		item.Comments = comments
+		item.Prefixes = nil
+		item.Modified = true
 	}
 }
 
-// Sections returns the list of defined sections, excluding the global one.
+// SetInlineComment sets the comment on the same line as the given key.
+// An empty comment removes it.
+func (ini *INI) SetInlineComment(section, key, comment string) {
+	if item := ini.getSection(section).getItem(key, ini.isCaseSensitive); item != nil {
+		item.InlineComment = comment
+		item.InlinePrefix = ""
+		item.Modified = true
+	}
+}
+
+// Sections returns the list of defined sections, excluding the global one
+// and the one set by the DefaultSection option.
 func (ini *INI) Sections() []string {
-	sections := make([]string, len(ini.sections))
-	for i, s := range ini.sections {
-		sections[i] = s.Name
+	sections := make([]string, 0, len(ini.sections))
+	for _, s := range ini.sections {
+		if ini.hasDefaults && ini.sectionIs(s, ini.defaultSection) {
+			continue
+		}
+		sections = append(sections, s.Name)
 	}
 	return sections
 }
@@ -210,17 +448,40 @@ func (ini *INI) Keys(section string) []string {
 		return nil
 	}
 
-	keys := make([]string, len(s.Data))
-	for i, items := range s.Data {
+	items := ini.items(s)
+	keys := make([]string, len(items))
+	for i, item := range items {
 		var key string
-		if items != nil {
-			key = items.Key
+		if item != nil {
+			key = item.Key
 		}
 		keys[i] = key
 	}
 	return keys
 }
 
+// items returns the items of the section, followed by the inherited and
+// default ones not overridden by the section.
+func (ini *INI) items(s *iniSection) []*iniItem {
+	items := s.Data[:len(s.Data):len(s.Data)]
+	lineage := ini.lineage(s)
+	for i, d := range lineage[1:] {
+	next:
+		for _, item := range d.Data {
+			if item == nil {
+				continue
+			}
+			for _, o := range lineage[:i+1] {
+				if o.getItem(item.Key, ini.isCaseSensitive) != nil {
+					continue next
+				}
+			}
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Del removes a section or key from Ini returning whether or not it did.
 // Set the key to an empty string to remove a section.
 func (ini *INI) Del(section, key string) bool {
@@ -234,8 +495,13 @@ func (ini *INI) Del(section, key string) bool {
 		return ini.rmSection(section)
 	}
 
-	// Remove the key for the section.
-	return ini.getSection(section).rmItem(key, ini.isCaseSensitive)
+	// Remove all the values of the key for the section.
+	sec := ini.getSection(section)
+	var ok bool
+	for sec.rmItem(key, ini.isCaseSensitive) {
+		ok = true
+	}
+	return ok
 }
 
 // ident returns a lowercased identifier if required.
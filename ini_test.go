@@ -8,7 +8,9 @@ import (
 	"io"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"testing/iotest"
 	"time"
 
@@ -286,6 +288,79 @@ func TestInvalidIni(t *testing.T) {
 	}
 }
 
+func TestParseError(t *testing.T) {
+	conf, _ := ini.New()
+
+	for _, tc := range []struct {
+		data   string
+		line   int
+		column int
+		kind   error
+	}{
+		{"[sectionA", 1, 10, ini.ErrMissingBracket},
+		{"a = 1\n  []", 2, 3, ini.ErrEmptySectionName},
+		{"[s]\nkey", 2, 1, ini.ErrMissingEquals},
+		{"key = 'xyz", 1, 7, ini.ErrUnterminatedQuote},
+	} {
+		_, err := conf.ReadFrom(bytes.NewBufferString(tc.data))
+		if !errors.Is(err, tc.kind) {
+			t.Fatalf("%q: got %v; want %v", tc.data, err, tc.kind)
+		}
+		var perr *ini.ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%q: got %T; want *ini.ParseError", tc.data, err)
+		}
+		if got, want := [2]int{perr.Line, perr.Column}, [2]int{tc.line, tc.column}; got != want {
+			t.Fatalf("%q: got %v; want %v", tc.data, got, want)
+		}
+	}
+
+	perr := &ini.ParseError{File: "app.ini", Line: 3, Column: 5, Text: "\tk = 'v", Err: ini.ErrUnterminatedQuote}
+	if got, want := perr.Error(), "ini: app.ini:3:5: string literal not terminated"; got != want {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	if got, want := perr.Snippet(), "\tk = 'v\n\t   ^"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	data := `a = 1
+[sectionA
+b = 2
+
+[s]
+c
+d = 'x
+e = 5
+`
+	conf, _ := ini.New(ini.ContinueOnError())
+
+	_, err := conf.ReadFrom(bytes.NewBufferString(data))
+	var errs ini.ParseErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %T; want ini.ParseErrors", err)
+	}
+	var lines []int
+	for _, err := range errs {
+		lines = append(lines, err.Line)
+	}
+	if got, want := lines, []int{2, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	if !errors.Is(err, ini.ErrMissingEquals) {
+		t.Fatalf("expected %v in %v", ini.ErrMissingEquals, err)
+	}
+
+	// Valid lines are still available.
+	if got, want := conf.Get("", "b"), "2"; got != want {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	if got, want := conf.Keys("s"), []string{"e", ""}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+}
+
 func TestInvalidDecode(t *testing.T) {
 	conf, _ := ini.New()
 
@@ -756,6 +831,34 @@ k2   = v1.2
 	}
 }
 
+func TestMergingReopenedSection(t *testing.T) {
+	// The keys preceding a reopened section belong to the previous one.
+	data := `[a]
+x = 1
+[b]
+y = 2
+[a]
+z = 3
+`
+	conf, _ := ini.New(ini.MergeSections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		section, key string
+		has          bool
+	}{
+		{"a", "x", true},
+		{"a", "y", false},
+		{"a", "z", true},
+		{"b", "y", true},
+	} {
+		if got, want := conf.Has(tc.section, tc.key), tc.has; got != want {
+			t.Errorf("%s.%s: got %v; want %v", tc.section, tc.key, got, want)
+		}
+	}
+}
+
 func TestDefaultOptions(t *testing.T) {
 	type config struct {
 		AS int            `ini:"a,S"`
@@ -1160,3 +1263,1817 @@ keyA2 = 2
 		t.Fatalf("got '%v'; want '%v'", got, want)
 	}
 }
+
+func TestContinuation(t *testing.T) {
+	data := `[jvm]
+args = -Xms1g \
+       -Xmx2g \
+  -verbose
+name = "quoted \\"
+next = a\
+`
+	conf, _ := ini.New(ini.Continuation(ini.BackslashContinuation), ini.WrapValues(20))
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("jvm", "args"), "-Xms1g -Xmx2g -verbose"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Get("jvm", "name"), "quoted \\"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Get("jvm", "next"), "a"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	conf.Del("jvm", "name")
+	conf.Del("jvm", "next")
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `[jvm]
+args = -Xms1g \
+       -Xmx2g \
+       -verbose
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	data = `[python]
+list = a
+  b
+	c
+key = value
+`
+	conf, _ = ini.New(ini.Continuation(ini.IndentContinuation))
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("python", "list"), "a\nb\nc"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want = `[python]
+list = a
+       b
+       c
+key  = value
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestInlineComments(t *testing.T) {
+	data := `[http]
+port = 8080 ; http
+url  = http://host/a;b
+name = "a ; b" ;quoted
+none = ; nothing
+`
+	conf, _ := ini.New(ini.InlineComments())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		key, value, comment string
+	}{
+		{"port", "8080", " http"},
+		{"url", "http://host/a;b", ""},
+		{"name", "a ; b", "quoted"},
+		{"none", "", " nothing"},
+	} {
+		if got, want := conf.Get("http", tc.key), tc.value; got != want {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+		if got, want := conf.GetInlineComment("http", tc.key), tc.comment; got != want {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+	}
+
+	conf.Del("http", "name")
+	conf.SetInlineComment("http", "url", " endpoint")
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `[http]
+port = 8080 ; http
+url  = http://host/a;b ; endpoint
+none = ; nothing
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestCommentPrefixes(t *testing.T) {
+	data := `; semicolon
+# hash
+// slashes
+[s]
+#key comment
+k = v ;; inline
+`
+	conf, _ := ini.New(ini.Comment("#", ";", "//", ";;"), ini.InlineComments())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := conf.GetComments("s", ""), []string{" semicolon", " hash", " slashes"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if got, want := conf.GetInlineComment("s", "k"), " inline"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Unchanged comments keep their prefix, new ones use the first one.
+	conf.Set("s", "k2", "v2")
+	conf.SetComments("s", "k2", "new")
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `; semicolon
+# hash
+// slashes
+[s]
+#key comment
+k = v ;; inline
+
+#new
+k2 = v2
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestDelimiters(t *testing.T) {
+	data := `[mysqld]
+port: 3306
+url = http://host:80
+dir := /var/lib
+`
+	conf, _ := ini.New(ini.Delimiters("=", ":", ":="))
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range map[string]string{
+		"port": "3306",
+		"url":  "http://host:80",
+		"dir":  "/var/lib",
+	} {
+		if got, want := conf.Get("mysqld", key), value; got != want {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+	}
+
+	// Original delimiters are kept.
+	buf := bytes.NewBuffer(nil)
+	conf.Set("mysqld", "user", "mysql")
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `[mysqld]
+port : 3306
+url  = http://host:80
+dir  := /var/lib
+
+user = mysql
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Normalised delimiters.
+	conf, _ = ini.New(ini.Delimiters("=", ":"), ini.WriteDelimiter(":"))
+	if _, err := conf.ReadFrom(bytes.NewBufferString("a = 1\nbb: 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a  : 1\nbb : 2\n"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestBareKeys(t *testing.T) {
+	data := `[mysqld]
+skip-networking
+user =
+verbose ; flag
+`
+	conf, _ := ini.New(ini.BareKeys(), ini.InlineComments())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !conf.IsBare("mysqld", "skip-networking") || !conf.IsBare("mysqld", "verbose") {
+		t.Fatal("expected bare keys")
+	}
+	if conf.IsBare("mysqld", "user") || !conf.Has("mysqld", "user") {
+		t.Fatal("expected key with an empty value")
+	}
+
+	var config struct {
+		SkipNetworking bool   `ini:"skip-networking,mysqld"`
+		Verbose        bool   `ini:"verbose,mysqld"`
+		User           string `ini:"user,mysqld"`
+	}
+	if err := conf.Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if !config.SkipNetworking || !config.Verbose {
+		t.Fatalf("got %+v; want bare keys set", config)
+	}
+
+	conf.SetBare("mysqld", "old-passwords")
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `[mysqld]
+skip-networking
+user = 
+verbose ; flag
+
+old-passwords
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Bare keys are errors by default.
+	conf, _ = ini.New()
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); !errors.Is(err, ini.ErrMissingEquals) {
+		t.Fatalf("got %v; want %v", err, ini.ErrMissingEquals)
+	}
+}
+
+func TestMultiValues(t *testing.T) {
+	data := `[Service]
+ExecStartPre = /bin/mkdir -p /run/app
+ExecStart = /bin/app
+ExecStartPre = /bin/chown app /run/app
+
+ExecStartPre = /bin/true
+`
+	conf, _ := ini.New(ini.MultiValues())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	pre := []string{"/bin/mkdir -p /run/app", "/bin/chown app /run/app", "/bin/true"}
+	if got, want := conf.GetAll("Service", "ExecStartPre"), pre; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	// The last value wins for Get.
+	if got, want := conf.Get("Service", "ExecStartPre"), "/bin/true"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	type service struct {
+		ExecStartPre []string `ini:",Service"`
+		ExecStart    []string `ini:",Service"`
+	}
+	var svc service
+	if err := conf.Decode(&svc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := svc, (service{pre, []string{"/bin/app"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	conf.Add("Service", "ExecStop", "/bin/kill a,b")
+	conf.Add("Service", "ExecStop", "/bin/kill c")
+	if got, want := conf.GetAll("Service", "ExecStop"), []string{"/bin/kill a,b", "/bin/kill c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if !conf.Del("Service", "ExecStartPre") || conf.Has("Service", "ExecStartPre") {
+		t.Fatal("expected all values to be removed")
+	}
+
+	conf, _ = ini.New(ini.MultiValues())
+	if err := conf.Encode(&svc); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `[Service]
+ExecStartPre = /bin/mkdir -p /run/app
+ExecStartPre = /bin/chown app /run/app
+ExecStartPre = /bin/true
+ExecStart    = /bin/app
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Values are still split on the SliceSeparator.
+	conf, _ = ini.New(ini.MultiValues())
+	if _, err := conf.ReadFrom(bytes.NewBufferString("ports = 1,2,3\nhosts = a\nhosts = b,c\n")); err != nil {
+		t.Fatal(err)
+	}
+	type lists struct {
+		Ports []int    `ini:"ports"`
+		Hosts []string `ini:"hosts"`
+	}
+	var l lists
+	if err := conf.Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l, (lists{[]int{1, 2, 3}, []string{"a", "b", "c"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	data := `a = "line1\nline2\ttab\\ \"q\" \x41\u00e9"
+b = 'C:\dir\n'
+c = "\z"
+`
+	conf, _ := ini.New()
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("", "a"), "line1\nline2\ttab\\ \"q\" A\u00e9"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Get("", "b"), `C:\dir\n`; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Get("", "c"), "z"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Values are quoted when required so that they are read back unchanged.
+	values := map[string]string{
+		"newline": "a\nb",
+		"spaces":  "  padded ",
+		"quote":   `"quoted"`,
+		"single":  "'single",
+		"comment": "value ; not a comment",
+		"plain":   `a "b" \c`,
+	}
+	conf, _ = ini.New(ini.InlineComments())
+	for k, v := range values {
+		conf.Set("s", k, v)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `plain   = a "b" \c`) {
+		t.Fatalf("expected unquoted value in %q", buf.String())
+	}
+	conf, _ = ini.New(ini.InlineComments())
+	if _, err := conf.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		if got, want := conf.Get("s", k), v; got != want {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+	}
+}
+
+func TestPreserveFormatting(t *testing.T) {
+	data := `;; Global comment
+
+name='app'   ; quoted
+  indented=1
+
+[server]
+# Hand kept alignment
+host    :    localhost
+port    =    80
+; orphan comment
+
+timeout = 5s
+[empty]
+bogus line
+; trailing comment
+`
+	conf, _ := ini.New(ini.PreserveFormatting(), ini.Delimiters("=", ":"), ini.ContinueOnError())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Unmodified content is written back as is.
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), data; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Only the modified lines are rewritten.
+	conf.Set("", "name", "web")
+	conf.Set("server", "port", "8080")
+	conf.Set("server", "host", "localhost")
+	conf.Del("server", "timeout")
+	conf.Set("server", "tls", "on")
+	conf.SetComments("empty", "", " no keys")
+	conf.Set("new", "k", "v")
+
+	buf.Reset()
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `;; Global comment
+
+name = web
+  indented=1
+
+[server]
+# Hand kept alignment
+host    :    localhost
+port = 8080
+; orphan comment
+
+tls = on
+; no keys
+[empty]
+bogus line
+; trailing comment
+
+[new]
+k = v
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Line endings are preserved.
+	data = "a = 1\r\n\r\n[s]\r\nb=2\r\n"
+	conf, _ = ini.New(ini.PreserveFormatting())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	conf.Set("s", "c", "3")
+	buf.Reset()
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), data+"c = 3\r\n"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	// Reopened sections are written back in place, as is the missing
+	// line ending of the last line.
+	for _, tc := range []struct {
+		data, set, want string
+	}{
+		{"[a]\nx=1\n[b]\ny=2\n; a again\n[a]\nz=3\n", "", ""},
+		{"[a]\nx=1\n[b]\ny=2\n; a again\n[a]\nz=3\n", "x", "[a]\nx = v\n[b]\ny=2\n; a again\n[a]\nz=3\n"},
+		{"[a]\nx=1\n[b]\ny=2\n; a again\n[a]\nz=3\n", "w", "[a]\nx=1\n[b]\ny=2\n; a again\n[a]\nz=3\nw = v\n"},
+		{"[a]\nx=1\n\n[a]\nz=3\n\n[b]\ny=2\n", "", ""},
+		{"a = 1\n[s]\nb=2", "", ""},
+		{"a = 1\n[s]\nb=2", "c", "a = 1\n[s]\nb=2\nc = v"},
+	} {
+		conf, _ := ini.New(ini.PreserveFormatting(), ini.MergeSections())
+		if _, err := conf.ReadFrom(bytes.NewBufferString(tc.data)); err != nil {
+			t.Fatal(err)
+		}
+		want := tc.data
+		if tc.set != "" {
+			conf.Set(conf.Sections()[0], tc.set, "v")
+			want = tc.want
+		}
+		buf.Reset()
+		if _, err := conf.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%q: got %q; want %q", tc.data, got, want)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	data := `root = /var/app
+[paths]
+log_dir = ${root}/log
+data_dir = ${paths.log_dir}/../data
+cost = $$5
+[python]
+name = %(root)s/py
+pct = 100%%
+[cycle]
+a = ${b}
+b = ${cycle.a}
+missing = ${nope}
+`
+	conf, _ := ini.New(ini.Interpolate(), ini.PythonInterpolation())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		section, key, want string
+	}{
+		{"paths", "log_dir", "/var/app/log"},
+		{"paths", "data_dir", "/var/app/log/../data"},
+		{"paths", "cost", "$5"},
+		{"python", "name", "/var/app/py"},
+		{"python", "pct", "100%"},
+	} {
+		if got := conf.Get(tc.section, tc.key); got != tc.want {
+			t.Errorf("%s.%s: got %q; want %q", tc.section, tc.key, got, tc.want)
+		}
+	}
+	if got, want := conf.GetRaw("paths", "log_dir"), "${root}/log"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	_, err := conf.Expand("cycle", "a")
+	var ierr *ini.InterpolationError
+	if !errors.As(err, &ierr) || !errors.Is(err, ini.ErrReferenceCycle) {
+		t.Fatalf("got %v; want a reference cycle", err)
+	}
+	if got, want := strings.Join(ierr.Chain, " -> "), "cycle.a -> cycle.b -> cycle.a"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if _, err := conf.Expand("cycle", "missing"); !errors.Is(err, ini.ErrMissingReference) {
+		t.Errorf("got %v; want a missing reference", err)
+	}
+	if got, want := conf.Get("cycle", "a"), "${b}"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	type paths struct {
+		LogDir  string `ini:"log_dir,paths"`
+		DataDir string `ini:"data_dir,paths"`
+	}
+	var p paths
+	if err := conf.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p, (paths{"/var/app/log", "/var/app/log/../data"}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// The raw values are written.
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "${root}/log") {
+		t.Errorf("got %q; want raw values", buf.String())
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"HOME": "/home/app", "TOKEN": "s3cr3t", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	data := `home = $HOME/data
+token = ${ENV:TOKEN}
+braced = ${HOME}
+port = ${PORT:-8080}
+empty = ${EMPTY:-none}
+unset = [$UNSET]
+price = $$10
+required = ${SECRET:?SECRET must be set}
+`
+	conf, _ := ini.New(ini.ExpandEnv(lookup))
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		key, want string
+	}{
+		{"home", "/home/app/data"},
+		{"token", "s3cr3t"},
+		{"braced", "/home/app"},
+		{"port", "8080"},
+		{"empty", "none"},
+		{"unset", "[]"},
+		{"price", "$10"},
+	} {
+		if got := conf.Get("", tc.key); got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.key, got, tc.want)
+		}
+	}
+
+	_, err := conf.Expand("", "required")
+	if !errors.Is(err, ini.ErrUndefinedEnv) {
+		t.Fatalf("got %v; want an undefined variable", err)
+	}
+	if got, want := err.Error(), "ini: interpolation: required -> ENV:SECRET: undefined environment variable: SECRET must be set"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	type config struct {
+		Port     int    `ini:"port"`
+		Required string `ini:"required"`
+	}
+	var c config
+	if err := conf.Decode(&c); !errors.Is(err, ini.ErrUndefinedEnv) {
+		t.Errorf("got %v; want an undefined variable", err)
+	}
+
+	// With key interpolation, ${NAME} is a key.
+	conf, _ = ini.New(ini.ExpandEnv(lookup), ini.Interpolate())
+	data = "dir = ${ENV:HOME}/x\nsub = ${dir}/y\nport = ${PORT:-80}\n"
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("", "sub"), "/home/app/x/y"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Get("", "port"), "80"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.ini":              {Data: []byte("name = app\n!include common/base.ini\n!includedir conf.d\n[server]\nport = 80\n")},
+		"common/base.ini":      {Data: []byte("[server]\nhost = localhost\n!include /common/log.ini\n")},
+		"common/log.ini":       {Data: []byte("[log]\nlevel = info\n")},
+		"conf.d/10-host.conf":  {Data: []byte("[server]\nhost = example.com\n")},
+		"conf.d/20-log.cnf":    {Data: []byte("[log]\nlevel = debug\n")},
+		"conf.d/README":        {Data: []byte("not included")},
+		"cycle/a.ini":          {Data: []byte("!include b.ini\n")},
+		"cycle/b.ini":          {Data: []byte("x = 1\n!include a.ini\n")},
+		"keys/main.ini":        {Data: []byte("include = extra\n[s]\nk = v\n")},
+		"keys/extra/more.conf": {Data: []byte("[t]\nk = w\n")},
+	}
+	read := func(name string, options ...ini.Option) (*ini.INI, error) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		conf, _ := ini.New(append(options, ini.Includes(fsys), ini.MergeSections())...)
+		_, err = conf.ReadFrom(namedReader{f, name})
+		return conf, err
+	}
+
+	conf, err := read("app.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		section, key, value, file string
+		line                      int
+	}{
+		{"", "name", "app", "app.ini", 1},
+		{"server", "host", "example.com", "conf.d/10-host.conf", 2},
+		{"server", "port", "80", "app.ini", 5},
+		{"log", "level", "debug", "conf.d/20-log.cnf", 2},
+	} {
+		if got := conf.Get(tc.section, tc.key); got != tc.value {
+			t.Errorf("%s.%s: got %q; want %q", tc.section, tc.key, got, tc.value)
+		}
+		file, line := conf.Source(tc.section, tc.key)
+		if file != tc.file || line != tc.line {
+			t.Errorf("%s.%s: got %s:%d; want %s:%d", tc.section, tc.key, file, line, tc.file, tc.line)
+		}
+	}
+
+	_, err = read("cycle/a.ini")
+	var perr *ini.ParseError
+	if !errors.As(err, &perr) || !errors.Is(err, ini.ErrIncludeCycle) {
+		t.Fatalf("got %v; want an include cycle", err)
+	}
+	if got, want := perr.File, "cycle/b.ini"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	if _, err := read("cycle/a.ini", ini.IncludeDepth(1)); !errors.Is(err, ini.ErrIncludeDepth) {
+		t.Errorf("got %v; want too many includes", err)
+	}
+
+	conf, err = read("keys/main.ini", ini.IncludeKey("include"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("t", "k"), "w"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if conf.Has("", "include") {
+		t.Error("include key should not be kept")
+	}
+
+	// Without a file system, the include key is a regular key.
+	conf, _ = ini.New(ini.IncludeKey("include"))
+	if _, err := conf.ReadFrom(bytes.NewBufferString("include = foo.ini\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("", "include"), "foo.ini"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// The directives are written back instead of the included keys.
+	conf, err = read("app.ini", ini.PreserveFormatting())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Set("server", "port", "8080")
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "name = app\n!include common/base.ini\n!includedir conf.d\n[server]\nport = 8080\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// namedReader names a reader like *os.File does.
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (r namedReader) Name() string { return r.name }
+
+func TestLayers(t *testing.T) {
+	var layers []*ini.INI
+	for _, data := range []string{
+		"[app]\nname = app\nport = 80\n[log]\nlevel = info\n",
+		"[app]\nport = 8080\n",
+		"[APP]\nDebug = true\n[db]\nhost = localhost\n",
+	} {
+		conf, _ := ini.New()
+		if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, conf)
+	}
+	l := ini.NewLayers(layers[:2]...)
+	l.Add(layers[2])
+
+	for _, tc := range []struct {
+		section, key, value string
+		origin              int
+	}{
+		{"app", "name", "app", 0},
+		{"app", "port", "8080", 1},
+		{"app", "debug", "true", 2},
+		{"log", "level", "info", 0},
+		{"app", "missing", "", -1},
+	} {
+		if got := l.Get(tc.section, tc.key); got != tc.value {
+			t.Errorf("%s.%s: got %q; want %q", tc.section, tc.key, got, tc.value)
+		}
+		if got := l.Origin(tc.section, tc.key); got != tc.origin {
+			t.Errorf("%s.%s: got layer %d; want %d", tc.section, tc.key, got, tc.origin)
+		}
+	}
+	if got, want := l.Sections(), []string{"app", "log", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got, want := l.Keys("app"), []string{"name", "port", "Debug"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	type config struct {
+		Name  string `ini:"name,app"`
+		Port  int    `ini:"port,app"`
+		Debug bool   `ini:"debug,app"`
+	}
+	var c config
+	if err := l.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c, (config{"app", 8080, true}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// Defaults only apply to keys missing from all layers.
+	type defaults struct {
+		Name  string `ini:"name,app" default:"none"`
+		Level string `ini:"level,log" default:"debug"`
+		Host  string `ini:"host,db" default:"db.local"`
+		User  string `ini:"user,db" default:"root"`
+	}
+	var d defaults
+	if err := l.Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d, (defaults{"app", "info", "localhost", "root"}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// Changes only apply to the chosen layer.
+	l.Layer(1).Set("app", "port", "9090")
+	buf := bytes.NewBuffer(nil)
+	if _, err := l.Layer(1).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "[app]\nport = 9090\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := l.Get("app", "port"), "9090"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// References are resolved across layers, highest priority first.
+	layers = nil
+	for _, data := range []string{
+		"base = /v\n",
+		"data = ${base}/d\n",
+		"",
+	} {
+		conf, _ := ini.New(ini.Interpolate(), ini.DisallowUnknownKeys())
+		if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, conf)
+	}
+	l = ini.NewLayers(layers...)
+	if got, want := l.Get("", "data"), "/v/d"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	type paths struct {
+		Data string `ini:"data"`
+	}
+	// The referred keys are not unknown.
+	var p paths
+	if err := l.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Data, "/v/d"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	layers[2].Set("", "base", "/w")
+	if got, want := l.Get("", "data"), "/w/d"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if err := l.Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Data, "/w/d"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestDefaultSection(t *testing.T) {
+	data := `[DEFAULT]
+timeout = 30
+user = admin
+
+[server]
+host = example.com
+timeout = 10
+
+[db]
+host = localhost
+`
+	conf, _ := ini.New(ini.DefaultSection("DEFAULT"))
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		section, key, value string
+	}{
+		{"server", "timeout", "10"},
+		{"server", "user", "admin"},
+		{"db", "timeout", "30"},
+		{"", "timeout", ""},
+		{"missing", "timeout", ""},
+	} {
+		if got := conf.Get(tc.section, tc.key); got != tc.value {
+			t.Errorf("%s.%s: got %q; want %q", tc.section, tc.key, got, tc.value)
+		}
+		if got, want := conf.Has(tc.section, tc.key), tc.value != ""; got != want {
+			t.Errorf("%s.%s: got %v; want %v", tc.section, tc.key, got, want)
+		}
+	}
+	if got, want := conf.Sections(), []string{"server", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got, want := conf.Keys("db"), []string{"host", "", "timeout", "user"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	type config struct {
+		Host    string `ini:"host,db"`
+		Timeout int    `ini:"timeout,db"`
+	}
+	var c config
+	if err := conf.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c, (config{"localhost", 30}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// Inherited values are not written in each section.
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(buf.String(), "timeout"); got != 2 {
+		t.Errorf("got %d timeout keys; want 2 in %q", got, buf.String())
+	}
+
+	// The global section supplies the defaults.
+	conf, _ = ini.New(ini.DefaultSection(""))
+	if _, err := conf.ReadFrom(bytes.NewBufferString("user = root\n[s]\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("s", "user"), "root"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestSectionInheritance(t *testing.T) {
+	data := `[base]
+host = localhost
+port = 80
+debug = true
+
+[staging : base]
+host = staging.example.com
+
+[prod:staging]
+debug = false
+`
+	conf, _ := ini.New(ini.SectionInheritance())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		section, key, value string
+	}{
+		{"prod", "host", "staging.example.com"},
+		{"prod", "port", "80"},
+		{"prod", "debug", "false"},
+		{"staging", "debug", "true"},
+		{"base", "host", "localhost"},
+	} {
+		if got := conf.Get(tc.section, tc.key); got != tc.value {
+			t.Errorf("%s.%s: got %q; want %q", tc.section, tc.key, got, tc.value)
+		}
+	}
+	if got, want := conf.Parent("prod"), "staging"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Keys("prod"), []string{"debug", "", "host", "port"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	type config struct {
+		Host  string `ini:"host,prod"`
+		Port  int    `ini:"port,prod"`
+		Debug bool   `ini:"debug,prod"`
+	}
+	var c config
+	if err := conf.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c, (config{"staging.example.com", 80, false}); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// The header syntax is kept.
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "[staging : base]\nhost = staging.example.com\n"; !strings.Contains(got, want) {
+		t.Errorf("got %q; want %q in it", got, want)
+	}
+
+	for _, tc := range []struct {
+		data string
+		err  error
+		line int
+		col  int
+	}{
+		{"[a : missing]\n", ini.ErrMissingParent, 1, 6},
+		{"[a : b]\nk = v\n[b : a]\n", ini.ErrInheritanceCycle, 1, 6},
+		{"[a : a]\n", ini.ErrInheritanceCycle, 1, 6},
+	} {
+		conf, _ := ini.New(ini.SectionInheritance())
+		_, err := conf.ReadFrom(bytes.NewBufferString(tc.data))
+		var perr *ini.ParseError
+		if !errors.As(err, &perr) || !errors.Is(err, tc.err) {
+			t.Errorf("%q: got %v; want %v", tc.data, err, tc.err)
+			continue
+		}
+		if perr.Line != tc.line || perr.Column != tc.col {
+			t.Errorf("%q: got %d:%d; want %d:%d", tc.data, perr.Line, perr.Column, tc.line, tc.col)
+		}
+	}
+}
+
+func TestQuotedSubsections(t *testing.T) {
+	data := `[core]
+bare = false
+[remote "origin"]
+url = git@example.com:app.git
+fetch = +refs/heads/*:refs/remotes/origin/*
+[Remote "Upstream"]
+url = https://example.com/app.git
+[branch "feature/x \"y\""]
+remote = origin
+`
+	conf, _ := ini.New(ini.QuotedSubsections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Subsections("REMOTE"), []string{"origin", "Upstream"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	for _, tc := range []struct {
+		section, key, value string
+	}{
+		{"remote.origin", "url", "git@example.com:app.git"},
+		{"REMOTE.origin", "url", "git@example.com:app.git"},
+		{"remote.upstream", "url", ""},
+		{"remote.Upstream", "url", "https://example.com/app.git"},
+		{`branch.feature/x "y"`, "remote", "origin"},
+	} {
+		if got := conf.Get(tc.section, tc.key); got != tc.value {
+			t.Errorf("%s.%s: got %q; want %q", tc.section, tc.key, got, tc.value)
+		}
+	}
+
+	type remote struct {
+		URL   string `ini:"url"`
+		Fetch string `ini:"fetch"`
+	}
+	type config struct {
+		Remotes map[string]remote                   `ini:"remote"`
+		Branch  map[string]*struct{ Remote string } `ini:"branch"`
+	}
+	var c config
+	if err := conf.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]remote{
+		"origin":   {"git@example.com:app.git", "+refs/heads/*:refs/remotes/origin/*"},
+		"Upstream": {URL: "https://example.com/app.git"},
+	}
+	if got := c.Remotes; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if b := c.Branch[`feature/x "y"`]; b == nil || b.Remote != "origin" {
+		t.Errorf("got %v; want remote origin", c.Branch)
+	}
+
+	// Subsections are written quoted.
+	conf.Set("remote.new", "url", "x")
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`[Remote "Upstream"]`, `[branch "feature/x \"y\""]`, `[remote "new"]`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("got %q; want %s in it", buf.String(), s)
+		}
+	}
+
+	// Only quoted subsections are written quoted and case sensitive.
+	data = `[Server.TLS]
+cert = a.pem
+[a.b "c"]
+k = 1
+[a "b.c"]
+k = 2
+[a "b.c"]
+k = 3
+`
+	conf, _ = ini.New(ini.QuotedSubsections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("server.tls", "cert"), "a.pem"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Sections(), []string{"Server.TLS", "a.b.c", "a.b.c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	buf.Reset()
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want2 := `[Server.TLS]
+cert = a.pem
+
+[a.b "c"]
+k = 1
+
+[a "b.c"]
+k = 3
+`
+	if got := buf.String(); got != want2 {
+		t.Errorf("got %q; want %q", got, want2)
+	}
+}
+
+func TestNestedStructs(t *testing.T) {
+	type tls struct {
+		Cert string `ini:"cert"`
+		Key  string `ini:"key"`
+	}
+	type server struct {
+		Host string `ini:"host"`
+		TLS  tls    `ini:"tls"`
+		Mtls *tls   `ini:"mtls"`
+	}
+	type config struct {
+		Name    string    `ini:"name"`
+		Started time.Time `ini:"started"`
+		Server  server    `ini:"server"`
+		Admin   *server   `ini:"admin"`
+		Unused  *server   `ini:"unused"`
+	}
+	data := `name = app
+started = 2020-01-02
+
+[server]
+host = example.com
+
+[server.tls]
+cert = server.crt
+key = server.key
+
+[admin.mtls]
+cert = admin.crt
+`
+	var c config
+	if err := ini.Decode(bytes.NewBufferString(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		Name:    "app",
+		Started: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Server:  server{Host: "example.com", TLS: tls{"server.crt", "server.key"}},
+		Admin:   &server{Mtls: &tls{Cert: "admin.crt"}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %+v; want %+v", c, want)
+	}
+
+	// Round trip with another separator.
+	conf, _ := ini.New(ini.SectionSeparator("/"))
+	if err := conf.Encode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Get("server/tls", "cert"), "server.crt"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if conf.Has("unused", "") || conf.Has("server/mtls", "") {
+		t.Error("nil structs should not be encoded")
+	}
+	var c2 config
+	if err := conf.Decode(&c2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c2, c) {
+		t.Errorf("got %+v; want %+v", c2, c)
+	}
+}
+
+func TestNestedEmbedded(t *testing.T) {
+	type Common struct {
+		Timeout int    `ini:"timeout"`
+		Addr    string `ini:"addr"`
+	}
+	type srv struct {
+		Common
+		Host string `ini:"host"`
+	}
+	type peer struct {
+		Common
+	}
+	type config struct {
+		Server srv    `ini:"server"`
+		Peers  []peer `ini:"peer"`
+	}
+	data := `[server]
+host = example.com
+timeout = 5
+
+[[peer]]
+timeout = 1
+`
+	conf, _ := ini.New(ini.DisallowUnknownKeys(), ini.ArraySections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	var c config
+	if err := conf.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		Server: srv{Common{Timeout: 5}, "example.com"},
+		Peers:  []peer{{Common{Timeout: 1}}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %+v; want %+v", c, want)
+	}
+
+	// Round trip.
+	enc, _ := ini.New(ini.ArraySections())
+	if err := enc.Encode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := enc.Get("server", "timeout"), "5"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Errors from embedded fields can be inspected.
+	for _, data := range []string{
+		"[server]\naddr = ${missing}\n",
+		"[[peer]]\naddr = ${missing}\n",
+	} {
+		conf, _ := ini.New(ini.Interpolate(), ini.ArraySections())
+		if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+			t.Fatal(err)
+		}
+		var ierr *ini.InterpolationError
+		if err := conf.Decode(&c); !errors.As(err, &ierr) || !errors.Is(err, ini.ErrMissingReference) {
+			t.Errorf("%q: got %v; want a missing reference", data, err)
+		}
+	}
+}
+
+func TestSectionMapsAndArrays(t *testing.T) {
+	type backend struct {
+		URL    string `ini:"url"`
+		Weight int    `ini:"weight"`
+	}
+	type peer struct {
+		Name string `ini:"name"`
+		Port int    `ini:"port"`
+	}
+	type config struct {
+		Backends map[string]backend `ini:"backend"`
+		Caches   map[string]*backend
+		Peers    []peer  `ini:"peer"`
+		Nodes    []*peer `ini:"node"`
+	}
+	decode := func(r io.Reader, v interface{}) error {
+		conf, _ := ini.New(ini.ArraySections())
+		if _, err := conf.ReadFrom(r); err != nil {
+			return err
+		}
+		return conf.Decode(v)
+	}
+	data := `[backend.a]
+url = http://a
+weight = 1
+
+[backend.b]
+url = http://b
+
+[[peer]]
+name = alpha
+port = 1
+
+[[peer]]
+name = beta
+`
+	var c config
+	if err := decode(bytes.NewBufferString(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		Backends: map[string]backend{"a": {"http://a", 1}, "b": {URL: "http://b"}},
+		Peers:    []peer{{"alpha", 1}, {Name: "beta"}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %+v; want %+v", c, want)
+	}
+
+	// Encode does the reverse.
+	c.Caches = map[string]*backend{"x": {URL: "http://x"}}
+	c.Nodes = []*peer{{Name: "n"}}
+	c.Peers = c.Peers[1:]
+	conf, _ := ini.New(ini.ArraySections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Encode(&c); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(buf.String(), "[[peer]]"), 1; got != want {
+		t.Errorf("got %d array sections; want %d in %q", got, want, buf.String())
+	}
+	var c2 config
+	if err := decode(buf, &c2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c2, c) {
+		t.Errorf("got %+v; want %+v", c2, c)
+	}
+
+	// Array elements may refer to keys of other sections.
+	conf, _ = ini.New(ini.Interpolate(), ini.ArraySections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString("[node]\nport = 7\n[[peer]]\nport = ${node.port}\n")); err != nil {
+		t.Fatal(err)
+	}
+	var c3 config
+	if err := conf.Decode(&c3); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c3.Peers, []peer{{Port: 7}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	// But they cannot hold nested sections.
+	type tls struct {
+		Cert string `ini:"cert"`
+	}
+	type tlsPeer struct {
+		Name string `ini:"name"`
+		TLS  tls    `ini:"tls"`
+	}
+	var c4 struct {
+		Peers []tlsPeer `ini:"peer"`
+	}
+	want4 := "ini: decode: peer: TLS: nested sections are not supported in array elements"
+	if err := decode(bytes.NewBufferString("[[peer]]\nname = x\n"), &c4); err == nil || err.Error() != want4 {
+		t.Errorf("got %v; want %s", err, want4)
+	}
+	c4.Peers = []tlsPeer{{"x", tls{"c.pem"}}}
+	want4 = "ini: encode: peer: TLS: nested sections are not supported in array elements"
+	conf, _ = ini.New(ini.ArraySections())
+	if err := conf.Encode(&c4); err == nil || err.Error() != want4 {
+		t.Errorf("got %v; want %s", err, want4)
+	}
+
+	// Array sections are only parsed and encoded with the ArraySections option.
+	conf, _ = ini.New()
+	if _, err := conf.ReadFrom(bytes.NewBufferString("[[peer]]\nname = a\n[[peer]]\nport = 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Sections(), []string{"[peer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := conf.Get("[peer", "name")+conf.Get("[peer", "port"), "2"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	var c5 config
+	if err := conf.Decode(&c5); err != nil || c5.Peers != nil {
+		t.Errorf("got %v, %+v; want no peers", err, c5.Peers)
+	}
+	want5 := "ini: encode: peer: slices of structs require the ArraySections option"
+	if err := conf.Encode(&c); err == nil || err.Error() != want5 {
+		t.Errorf("got %v; want %s", err, want5)
+	}
+}
+
+func TestDefaultTag(t *testing.T) {
+	type config struct {
+		Host    string        `ini:"host" default:"localhost"`
+		Port    int           `ini:"port" default:"8080"`
+		Timeout time.Duration `ini:"timeout,server" default:"5s"`
+		Tags    []string      `ini:"tags" default:"a,b"`
+		Name    string        `ini:"name"`
+	}
+	c := config{Name: "kept"}
+	if err := ini.Decode(bytes.NewBufferString("port = 80\n"), &c); err != nil {
+		t.Fatal(err)
+	}
+	want := config{"localhost", 80, 5 * time.Second, []string{"a", "b"}, "kept"}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %+v; want %+v", c, want)
+	}
+
+	type invalid struct {
+		Port int `ini:"port" default:"http"`
+	}
+	if err := ini.Decode(bytes.NewBufferString(""), &invalid{}); err == nil {
+		t.Error("expected error")
+	}
+
+	// Fields holding their default value are left out.
+	conf, _ := ini.New(ini.OmitDefaults())
+	if err := conf.Encode(&c); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := conf.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "port = 80\nname = kept\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestRequiredAndUnknownKeys(t *testing.T) {
+	type tls struct {
+		Cert string `ini:"cert,,required"`
+	}
+	type config struct {
+		Host    string        `ini:"host,server,required"`
+		Port    int           `ini:"port,server,true,required"`
+		Timeout time.Duration `ini:"timeout,server"`
+		TLS     *tls          `ini:"tls,server,required"`
+		Name    string        `ini:"name"`
+		Root    string        `ini:"root"`
+	}
+	data := `name = app
+dir = /var
+root = ${dir}/app
+
+[server]
+port = 80
+tiemout = 5s
+
+[extra]
+k = v
+`
+	conf, _ := ini.New(ini.DisallowUnknownKeys(), ini.Interpolate())
+	if _, err := conf.ReadFrom(namedReader{bytes.NewBufferString(data), "app.ini"}); err != nil {
+		t.Fatal(err)
+	}
+	var c config
+	err := conf.Decode(&c)
+	var errs ini.KeyErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v; want KeyErrors", err)
+	}
+	want := []string{
+		"ini: decode: server.host: missing required key",
+		"ini: decode: server.tls: missing required key",
+		"ini: decode: app.ini:7: server.tiemout: unknown key",
+		"ini: decode: app.ini:10: extra.k: unknown key",
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if !errors.Is(err, ini.ErrMissingKey) || !errors.Is(err, ini.ErrUnknownKey) {
+		t.Errorf("got %v; want both kinds of errors", err)
+	}
+	if got, want := c.Root, "/var/app"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Keys may be supplied by any layer.
+	var layers []*ini.INI
+	for _, data := range []string{"[server]\nhost = h\n", "[server]\nport = 1\n[server.tls]\n"} {
+		conf, _ := ini.New()
+		if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, conf)
+	}
+	err = ini.NewLayers(layers...).Decode(&c)
+	if got, want := fmt.Sprint(err), "ini: decode: server.tls.cert: missing required key"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestDecodeMeta(t *testing.T) {
+	type backend struct {
+		Addr string `ini:"addr"`
+	}
+	type tls struct {
+		Cert string `ini:"cert"`
+	}
+	type config struct {
+		Name     string             `ini:"name"`
+		Port     int                `ini:"port,server" default:"8080"`
+		Host     string             `ini:"host,server"`
+		TLS      *tls               `ini:"tls,server"`
+		Backends map[string]backend `ini:"backend"`
+	}
+	data := `name = app
+
+[server]
+host = localhost
+debug = true
+
+[backend.a]
+addr = 10.0.0.1
+`
+	conf, _ := ini.New()
+	if _, err := conf.ReadFrom(namedReader{bytes.NewBufferString(data), "app.ini"}); err != nil {
+		t.Fatal(err)
+	}
+	var c config
+	meta, err := conf.DecodeMeta(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDecoded := []ini.MetaKey{
+		{Section: "", Key: "name", File: "app.ini", Line: 1, Field: "Name"},
+		{Section: "server", Key: "host", File: "app.ini", Line: 4, Field: "Host"},
+		{Section: "backend.a", Key: "addr", File: "app.ini", Line: 8, Field: "Backends[a].Addr"},
+	}
+	if got, want := meta.Decoded, wantDecoded; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	wantUndecoded := []ini.MetaKey{
+		{Section: "server", Key: "debug", File: "app.ini", Line: 5},
+	}
+	if got, want := meta.Undecoded, wantUndecoded; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got, want := meta.Defaulted, []string{"Port"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := meta.Untouched, []string{"TLS"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if k, ok := meta.Lookup("Backends[a].Addr"); !ok || k.Line != 8 {
+		t.Errorf("got %+v, %v; want line 8", k, ok)
+	}
+
+	// Decoding does not modify the INI and can be done concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var c2 config
+			if _, err := conf.DecodeMeta(&c2); err != nil {
+				t.Error(err)
+				return
+			}
+			if !reflect.DeepEqual(c2, c) {
+				t.Errorf("got %+v; want %+v", c2, c)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type validBase struct {
+	Name string `ini:"name"`
+}
+
+func (b *validBase) Validate() error {
+	if b.Name == "" {
+		return errors.New("empty name")
+	}
+	return nil
+}
+
+type validBackend struct {
+	Addr string `ini:"addr"`
+}
+
+func (b validBackend) Validate() error {
+	if b.Addr == "" {
+		return errors.New("empty address")
+	}
+	return nil
+}
+
+type validConfig struct {
+	validBase `ini:",global"`
+	Port      int                      `ini:"port"`
+	Backends  map[string]*validBackend `ini:"backend"`
+	Mirrors   []validBackend           `ini:"mirror"`
+}
+
+func (c *validConfig) Validate() error {
+	if c.Port <= 0 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	return nil
+}
+
+func TestValidator(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		err  string
+	}{
+		{"port = 80\n[global]\nname = app\n", ""},
+		{"port = 0\n[global]\nname = app\n", "ini: decode: invalid port 0"},
+		{"port = 80\n[global]\n", "ini: decode: global: empty name"},
+		{"[global]\nname = app\n[backend.a]\naddr = x\n[backend.b]\n", "ini: decode: backend.b: empty address"},
+		{"port = 80\n[global]\nname = app\n[[mirror]]\naddr = x\n[[mirror]]\n", "ini: decode: mirror[1]: empty address"},
+	} {
+		conf, _ := ini.New(ini.ArraySections())
+		if _, err := conf.ReadFrom(bytes.NewBufferString(tc.data)); err != nil {
+			t.Fatal(err)
+		}
+		var c validConfig
+		err := conf.Decode(&c)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("got %v; want no error", err)
+			}
+			continue
+		}
+		var verr *ini.ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("got %v; want ValidationError", err)
+		}
+		if got, want := fmt.Sprint(err), tc.err; got != want {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	}
+
+	// Promoted methods are only called once, for the embedded struct.
+	type config struct {
+		validBase
+		Port int `ini:"port"`
+	}
+	var c config
+	conf, _ := ini.New()
+	if _, err := conf.ReadFrom(bytes.NewBufferString("port = 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	err := ini.NewLayers(conf).Decode(&c)
+	if got, want := fmt.Sprint(err), "ini: decode: validBase: empty name"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestValidateTag(t *testing.T) {
+	type backend struct {
+		Addr string `ini:"addr" validate:"nonempty,match=^[a-z]+:[0-9]{1,5}$"`
+	}
+	type config struct {
+		Level    string        `ini:"level" validate:"oneof=debug|info|warn"`
+		Port     int           `ini:"port,server" validate:"min=1,max=65535"`
+		Timeout  time.Duration `ini:"timeout,server" validate:"max=1m"`
+		Tags     []string      `ini:"tags" validate:"nonempty"`
+		Backends []backend     `ini:"backend"`
+	}
+	data := `level = trace
+tags = a
+
+[server]
+port = 70000
+timeout = 30s
+
+[[backend]]
+addr = host:80
+
+[[backend]]
+addr = 10.0.0.1
+`
+	conf, _ := ini.New(ini.ArraySections())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	var c config
+	err := conf.Decode(&c)
+	var errs ini.RuleErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v; want RuleErrors", err)
+	}
+	want := []ini.RuleError{
+		{Section: "", Key: "level", Field: "Level", Rule: "oneof=debug|info|warn"},
+		{Section: "server", Key: "port", Field: "Port", Rule: "max=65535"},
+		{Section: "backend[1]", Key: "addr", Field: "Backends[1].Addr", Rule: "match=^[a-z]+:[0-9]{1,5}$"},
+	}
+	var got []ini.RuleError
+	for _, e := range errs {
+		got = append(got, *e)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got, want := errs[1].Error(), "ini: decode: server.port: Port does not satisfy max=65535"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Invalid rules are errors.
+	type invalid struct {
+		Name string `ini:"name" validate:"max=x"`
+	}
+	err = conf.Decode(&invalid{})
+	if got, want := fmt.Sprint(err), `ini: decode: Name: invalid rule "max=x": strconv.ParseFloat: parsing "x": invalid syntax`; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// pluginSettings holds free-form keys.
+type pluginSettings struct {
+	Name string
+	Keys []string
+	Env  map[string]string
+}
+
+func (p *pluginSettings) UnmarshalINISection(sec ini.Section) error {
+	p.Name = sec.Name
+	p.Env = map[string]string{}
+	for _, item := range sec.Items {
+		if item.Key == "" {
+			return errors.New("empty key")
+		}
+		p.Keys = append(p.Keys, item.Key)
+		p.Env[item.Key] = item.Value
+	}
+	return nil
+}
+
+func (p pluginSettings) MarshalINISection() (ini.Section, error) {
+	sec := ini.Section{Name: p.Name}
+	for _, key := range p.Keys {
+		sec.Items = append(sec.Items, ini.SectionItem{Key: key, Value: p.Env[key]})
+	}
+	return sec, nil
+}
+
+func TestSectionMarshaler(t *testing.T) {
+	type config struct {
+		Name   string          `ini:"name"`
+		Plugin *pluginSettings `ini:"plugin"`
+		Other  *pluginSettings `ini:"other"`
+	}
+	data := `name = app
+home = /opt
+
+[plugin]
+path = ${home}/bin
+debug = true
+`
+	conf, _ := ini.New(ini.Interpolate(), ini.DisallowUnknownKeys())
+	if _, err := conf.ReadFrom(bytes.NewBufferString(data)); err != nil {
+		t.Fatal(err)
+	}
+	var c config
+	meta, err := conf.DecodeMeta(&c)
+	if err != nil {
+		// The plugin keys are not unknown.
+		t.Fatal(err)
+	}
+	want := &pluginSettings{
+		Name: "plugin",
+		Keys: []string{"path", "debug"},
+		Env:  map[string]string{"path": "/opt/bin", "debug": "true"},
+	}
+	if got := c.Plugin; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got, want := meta.Untouched, []string{"Other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if k, ok := meta.Lookup("Plugin"); !ok || k.Key != "debug" || k.Line != 6 {
+		t.Errorf("got %+v, %v; want debug at line 6", k, ok)
+	}
+
+	// Encoding replaces the keys of the section.
+	c.Plugin = &pluginSettings{
+		Keys: []string{"debug", "level"},
+		Env:  map[string]string{"debug": "false", "level": "3"},
+	}
+	if err := conf.Encode(&c); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := conf.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wantData := `name = app
+home = /opt
+
+[plugin]
+debug = false
+
+level = 3
+`
+	if got, want := buf.String(), wantData; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Map entries with only nested sections are skipped.
+	var plugins struct {
+		Plugins map[string]*pluginSettings `ini:"plugins"`
+	}
+	conf, _ = ini.New()
+	if _, err := conf.ReadFrom(bytes.NewBufferString("[plugins.a.extra]\nkey = value\n[plugins.b]\nkey = b\n")); err != nil {
+		t.Fatal(err)
+	}
+	meta, err = conf.DecodeMeta(&plugins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPlugins := map[string]*pluginSettings{
+		"b": {Name: "plugins.b", Keys: []string{"key"}, Env: map[string]string{"key": "b"}},
+	}
+	if got := plugins.Plugins; !reflect.DeepEqual(got, wantPlugins) {
+		t.Errorf("got %+v; want %+v", got, wantPlugins)
+	}
+	if got, want := meta.Untouched, []string{"Plugins[a]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
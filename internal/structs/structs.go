@@ -1,6 +1,7 @@
 package structs
 
 import (
+	"encoding"
 	"fmt"
 	htemplate "html/template"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"strings"
 	"text/template"
 	"time"
+	"unsafe"
 
 	"github.com/kr/pretty"
 )
@@ -40,8 +42,24 @@ var (
 	regexpType       = reflect.TypeOf(regexp.MustCompile("."))
 	ipaddrType       = reflect.TypeOf(new(net.IPAddr))
 	ipnetType        = reflect.TypeOf(new(net.IPNet))
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
 
+// IsStruct returns whether or not the type is a struct, or a pointer to a
+// struct, made of fields rather than set from a single value as time.Time,
+// the other supported types and the encoding.TextUnmarshaler types are.
+func IsStruct(t reflect.Type) bool {
+	switch t {
+	case timeType, urlType, texttemplateType, htmltemplateType, regexpType, ipaddrType, ipnetType:
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
 // NewStruct recursively decomposes the input struct into its fields
 // and embedded structs.
 // Fields tags with "-" will be skipped.
@@ -83,6 +101,12 @@ func (f *StructField) Name() string {
 	return f.name
 }
 
+// FieldName returns the name of the field in its struct,
+// regardless of the struct tag.
+func (f *StructField) FieldName() string {
+	return f.field.Name
+}
+
 // Embedded returns the embedded struct if the field is embedded.
 func (f *StructField) Embedded() *StructStruct {
 	return f.embedded
@@ -244,11 +268,15 @@ func fieldsOf(v interface{}, tagid string) (res []*StructField) {
 	vType := value.Type()
 	for i, n := 0, value.NumField(); i < n; i++ {
 		value := value.Field(i)
+		field := vType.Field(i)
 		if !value.CanSet() {
-			// Cannot set the field, maybe unexported.
-			continue
+			if !field.Anonymous || value.Kind() != reflect.Struct {
+				// Cannot set the field, maybe unexported.
+				continue
+			}
+			// Unexported embedded struct: its exported fields are promoted.
+			value = reflect.NewAt(value.Type(), unsafe.Pointer(value.UnsafeAddr())).Elem()
 		}
-		field := vType.Field(i)
 		fname := field.Name
 
 		tag := field.Tag
@@ -0,0 +1,243 @@
+package ini
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	interpolateBraces = 1 << iota
+	interpolatePython
+)
+
+// Kinds of InterpolationError, to be used with errors.Is.
+var (
+	ErrMissingReference = errors.New("missing reference")
+	ErrReferenceCycle   = errors.New("reference cycle")
+	ErrUndefinedEnv     = errors.New("undefined environment variable")
+)
+
+// InterpolationError describes a value that cannot be expanded.
+type InterpolationError struct {
+	// Chain lists the keys followed to expand the value, as section.key,
+	// the last one being the one in error.
+	Chain []string
+	// Err is the kind of error, such as ErrReferenceCycle.
+	Err error
+}
+
+func (e *InterpolationError) Error() string {
+	return fmt.Sprintf("ini: interpolation: %s: %v", strings.Join(e.Chain, " -> "), e.Err)
+}
+
+// Unwrap returns the kind of error.
+func (e *InterpolationError) Unwrap() error {
+	return e.Err
+}
+
+// GetRaw fetches the key value in the given section without expanding it.
+// If the section or the key is not found an empty string is returned.
+func (ini *INI) GetRaw(section, key string) string {
+	if v := ini.get(section, key); v != nil {
+		return *v
+	}
+	return ""
+}
+
+// Expand fetches the key value in the given section and expands the
+// references it contains according to the Interpolate, PythonInterpolation
+// and ExpandEnv options.
+// If the section or the key is not found an empty string is returned.
+func (ini *INI) Expand(section, key string) (string, error) {
+	v := ini.get(section, key)
+	if v == nil {
+		return "", nil
+	}
+	return ini.expand(section, *v, []string{qualifiedKey(section, key)})
+}
+
+// expand replaces the references in value, which belongs to the given section.
+// chain lists the keys being expanded.
+func (ini *INI) expand(section, value string, chain []string) (string, error) {
+	if ini.interpolation == 0 && ini.lookupEnv == nil || !strings.ContainsAny(value, "$%") {
+		return value, nil
+	}
+	braces := ini.interpolation&interpolateBraces != 0
+	python := ini.interpolation&interpolatePython != 0
+	env := ini.lookupEnv != nil
+
+	var res strings.Builder
+	for value != "" {
+		i := strings.IndexAny(value, "$%")
+		if i < 0 {
+			res.WriteString(value)
+			break
+		}
+		res.WriteString(value[:i])
+		value = value[i:]
+
+		var name string
+		var braced bool
+		switch {
+		case (braces || env) && strings.HasPrefix(value, "$$"),
+			python && strings.HasPrefix(value, "%%"):
+			// Escaped character.
+			res.WriteByte(value[0])
+			value = value[2:]
+			continue
+		case (braces || env) && strings.HasPrefix(value, "${"):
+			if j := strings.IndexByte(value, '}'); j > 0 {
+				name, braced = value[2:j], true
+				value = value[j+1:]
+			}
+		case python && strings.HasPrefix(value, "%("):
+			if j := strings.Index(value, ")s"); j > 0 {
+				name = value[2:j]
+				value = value[j+2:]
+			}
+		case env && value[0] == '$':
+			if j := envNameLen(value[1:]); j > 0 {
+				s, err := ini.getenv(value[1:j+1], "", "", chain)
+				if err != nil {
+					return "", err
+				}
+				res.WriteString(s)
+				value = value[j+1:]
+				continue
+			}
+		}
+		if name == "" {
+			// Not a reference.
+			res.WriteByte(value[0])
+			value = value[1:]
+			continue
+		}
+
+		var s string
+		var err error
+		if v, op, arg, ok := ini.envReference(name, braced); ok {
+			s, err = ini.getenv(v, op, arg, chain)
+		} else {
+			s, err = ini.resolve(section, name, chain)
+		}
+		if err != nil {
+			return "", err
+		}
+		res.WriteString(s)
+	}
+	return res.String(), nil
+}
+
+// resolve returns the expanded value of the reference found in section.
+// The reference is either a key of the section or of the global section,
+// or a key of another section in the form section.key.
+func (ini *INI) resolve(section, name string, chain []string) (string, error) {
+	refSection, key := section, name
+	if i := strings.LastIndexByte(name, '.'); i >= 0 && ini.hasRefSection(name[:i]) {
+		refSection, key = name[:i], name[i+1:]
+	}
+	item := ini.refItem(refSection, key)
+	if item == nil && refSection == section {
+		refSection = ""
+		item = ini.refItem(refSection, key)
+	}
+
+	ref := qualifiedKey(refSection, key)
+	chain = append(chain[:len(chain):len(chain)], ref)
+	if item == nil {
+		return "", &InterpolationError{chain, ErrMissingReference}
+	}
+	ini.decoding.use(item)
+	for _, k := range chain[:len(chain)-1] {
+		if ident(ini.isCaseSensitive, k) == ident(ini.isCaseSensitive, ref) {
+			return "", &InterpolationError{chain, ErrReferenceCycle}
+		}
+	}
+	return ini.expand(refSection, item.Value, chain)
+}
+
+// hasRefSection returns whether or not the section referred to exists,
+// in any layer when resolving references across Layers.
+func (ini *INI) hasRefSection(section string) bool {
+	if ini.layers != nil {
+		return ini.layers.Has(section, "")
+	}
+	return ini.getSection(section) != nil
+}
+
+// refItem returns the item referred to, from the layer with the highest
+// priority having it when resolving references across Layers.
+// The keys of array elements are their own.
+func (ini *INI) refItem(section, key string) *iniItem {
+	if ini.layers != nil && !(section == "" && ini.global.Array) {
+		if i := ini.layers.Origin(section, key); i >= 0 {
+			return ini.layers.layers[i].getItem(section, key)
+		}
+		return nil
+	}
+	return ini.getItem(section, key)
+}
+
+// qualifiedKey returns the key prefixed with its section, if any.
+func qualifiedKey(section, key string) string {
+	if section == "" {
+		return key
+	}
+	return section + "." + key
+}
+
+// envReference parses a ${...} reference to an environment variable
+// into the variable name and its :- or :? operator and argument.
+// Without the ENV: prefix nor operator, the reference is a key
+// when the Interpolate option is set.
+func (ini *INI) envReference(ref string, braced bool) (name, op, arg string, ok bool) {
+	if ini.lookupEnv == nil || !braced {
+		return
+	}
+	name = ref
+	explicit := strings.HasPrefix(name, "ENV:")
+	if explicit {
+		name = name[len("ENV:"):]
+	}
+	for _, o := range []string{":-", ":?"} {
+		if i := strings.Index(name, o); i > 0 {
+			name, op, arg = name[:i], o, name[i+len(o):]
+			break
+		}
+	}
+	ok = explicit || op != "" || ini.interpolation&interpolateBraces == 0
+	return
+}
+
+// getenv returns the value of the environment variable name.
+// An undefined or empty variable is replaced by arg with the :- operator,
+// and is an error with the :? operator.
+func (ini *INI) getenv(name, op, arg string, chain []string) (string, error) {
+	v, ok := ini.lookupEnv(name)
+	if ok && v != "" || op == "" {
+		return v, nil
+	}
+	if op == ":-" {
+		return arg, nil
+	}
+	chain = append(chain[:len(chain):len(chain)], "ENV:"+name)
+	err := ErrUndefinedEnv
+	if arg != "" {
+		err = fmt.Errorf("%w: %s", ErrUndefinedEnv, arg)
+	}
+	return "", &InterpolationError{chain, err}
+}
+
+// envNameLen returns the length of the environment variable name
+// at the start of s, made of letters, digits and underscores.
+func envNameLen(s string) int {
+	for i, c := range s {
+		if c == '_' || unicode.IsLetter(c) || i > 0 && unicode.IsDigit(c) {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
@@ -0,0 +1,191 @@
+package ini
+
+// Layers resolves keys across several INI, such as system wide, user and
+// local configuration files. Later layers have priority over earlier ones.
+//
+// Changes are made to a single layer, obtained with Layer, which can then
+// be written back with its WriteTo method.
+type Layers struct {
+	layers []*INI
+}
+
+// NewLayers returns the Layers made of the given INI, by increasing priority.
+func NewLayers(layers ...*INI) *Layers {
+	return &Layers{layers: layers}
+}
+
+// Add adds a layer with the highest priority.
+func (l *Layers) Add(layer *INI) {
+	l.layers = append(l.layers, layer)
+}
+
+// Len returns the number of layers.
+func (l *Layers) Len() int {
+	return len(l.layers)
+}
+
+// Layer returns the layer at the given index.
+func (l *Layers) Layer(index int) *INI {
+	return l.layers[index]
+}
+
+// Origin returns the index of the layer supplying the key in the given
+// section, or -1 if none has it.
+// The file and line the key was read from are available with the Source
+// method of that layer.
+func (l *Layers) Origin(section, key string) int {
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		if l.layers[i].Has(section, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Has returns whether or not the section (if the key is empty) or
+// the key exists in any layer.
+func (l *Layers) Has(section, key string) bool {
+	for _, layer := range l.layers {
+		if layer.Has(section, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get fetches the key value in the given section from the layer with the
+// highest priority that has it.
+// If the section or the key is not found an empty string is returned.
+// References are resolved across the layers, such as ${base} to a key of
+// a layer with a lower priority.
+func (l *Layers) Get(section, key string) string {
+	if i := l.Origin(section, key); i >= 0 {
+		return l.view(i).Get(section, key)
+	}
+	return ""
+}
+
+// view returns a copy of the layer at the given index whose references
+// are resolved across the layers.
+func (l *Layers) view(index int) *INI {
+	layer := *l.layers[index]
+	layer.layers = l
+	return &layer
+}
+
+// Sections returns the list of sections defined in any layer,
+// excluding the global one, in the order they first appear.
+func (l *Layers) Sections() []string {
+	var sections []string
+	for _, layer := range l.layers {
+	next:
+		for _, name := range layer.Sections() {
+			for _, s := range sections {
+				if l.sameName(s, name) {
+					continue next
+				}
+			}
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+// Keys returns the list of keys for the given section defined in any layer,
+// in the order they first appear.
+func (l *Layers) Keys(section string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, layer := range l.layers {
+		for _, key := range layer.Keys(section) {
+			id := ident(layer.isCaseSensitive, key)
+			if key == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Decode decodes the layers into v, starting with the one with the lowest
+// priority so that the values of the following ones take precedence.
+// References are resolved across the layers, as with Get.
+// Required keys must be defined in at least one layer, default struct tags
+// only apply to keys no layer defines, and v is only validated once all
+// layers are decoded.
+func (l *Layers) Decode(v interface{}) error {
+	// Keys referred to from another layer are used.
+	used := map[*iniItem]bool{}
+	states := make([]*decodeState, len(l.layers))
+	for i := range l.layers {
+		states[i] = &decodeState{used: used, supplied: l.supplies}
+		if err := l.view(i).decodeAll(v, states[i]); err != nil {
+			return err
+		}
+	}
+	l.useOverridden(used)
+	var errs KeyErrors
+	seen := map[KeyError]bool{}
+	for i, layer := range l.layers {
+		state := states[i]
+		layer.undecoded(state)
+		for _, e := range layer.keyErrors(state) {
+			if e.Err == ErrMissingKey && (seen[*e] || l.supplies(e.Section, e.Key)) {
+				continue
+			}
+			seen[*e] = true
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if len(l.layers) == 0 {
+		return nil
+	}
+	return l.layers[len(l.layers)-1].validateAll(v)
+}
+
+// useOverridden records as used the keys overridden by a used key
+// of the layer with the highest priority.
+func (l *Layers) useOverridden(used map[*iniItem]bool) {
+	for _, layer := range l.layers {
+		for _, s := range append([]*iniSection{&layer.global}, layer.sections...) {
+			if s.Array {
+				continue
+			}
+			for _, item := range s.Data {
+				if item == nil || used[item] {
+					continue
+				}
+				if i := l.Origin(s.Name, item.Key); i >= 0 && used[l.layers[i].getItem(s.Name, item.Key)] {
+					used[item] = true
+				}
+			}
+		}
+	}
+}
+
+// supplies returns whether or not any layer has the key,
+// or the section or one nested in it if the key is empty.
+func (l *Layers) supplies(section, key string) bool {
+	for _, layer := range l.layers {
+		if key == "" && layer.hasNestedSections(section) || key != "" && layer.Has(section, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameName returns whether or not both section names refer to the same
+// section in every layer.
+func (l *Layers) sameName(a, b string) bool {
+	for _, layer := range l.layers {
+		if layer.getSection(a) != layer.getSection(b) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package ini
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pierrec/go-ini/internal/structs"
+)
+
+// Section lists the keys of a section, for the types decoding
+// or encoding a whole section, such as ones with free-form keys.
+type Section struct {
+	// Name is the name of the section. It is ignored by Encode.
+	Name string
+	// Items lists the keys in order. Keys may be repeated.
+	Items []SectionItem
+}
+
+// SectionItem is a key of a Section.
+type SectionItem struct {
+	Key   string
+	Value string
+	// Comments are the lines of comments preceding the key.
+	Comments []string
+}
+
+// SectionUnmarshaler is implemented by types decoding themselves from
+// the whole section they are mapped to, instead of from one key per field.
+// The section keys include the inherited and default ones, and their
+// values are expanded.
+type SectionUnmarshaler interface {
+	UnmarshalINISection(sec Section) error
+}
+
+// SectionMarshaler is implemented by types encoding themselves into the
+// whole section they are mapped to. The keys of the section not returned
+// are removed.
+type SectionMarshaler interface {
+	MarshalINISection() (Section, error)
+}
+
+var (
+	sectionUnmarshalType = reflect.TypeOf((*SectionUnmarshaler)(nil)).Elem()
+	sectionMarshalType   = reflect.TypeOf((*SectionMarshaler)(nil)).Elem()
+)
+
+// isSectionType returns whether or not the values of type t are mapped
+// to a whole section: structs and the types implementing iface.
+func isSectionType(t, iface reflect.Type) bool {
+	return structs.IsStruct(t) || implements(t, iface)
+}
+
+// implements returns whether or not t or a pointer to t implements iface.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(iface)
+}
+
+// unmarshalSection decodes the section with the UnmarshalINISection method of u.
+func (ini *INI) unmarshalSection(section string, u SectionUnmarshaler) error {
+	s := ini.getSection(section)
+	sec := Section{Name: s.Name}
+	var items []*iniItem
+	for _, item := range ini.items(s) {
+		if item == nil {
+			continue
+		}
+		value, err := ini.expand(section, item.Value, []string{qualifiedKey(section, item.Key)})
+		if err != nil {
+			return fmt.Errorf("ini: decode: %s.%s: %w", section, item.Key, err)
+		}
+		sec.Items = append(sec.Items, SectionItem{item.Key, value, item.Comments})
+		items = append(items, item)
+	}
+	state := ini.decoding
+	state.decodedAt(section, strings.Join(state.path, "."), items...)
+
+	if err := u.UnmarshalINISection(sec); err != nil {
+		return fmt.Errorf("ini: decode: %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// marshalSection replaces the keys of the section by the ones returned
+// by the MarshalINISection method of m.
+func (ini *INI) marshalSection(section string, m SectionMarshaler) error {
+	sec, err := m.MarshalINISection()
+	if err != nil {
+		return fmt.Errorf("ini: encode: %s: %w", section, err)
+	}
+
+	count := map[string]int{}
+	for _, item := range sec.Items {
+		count[ident(ini.isCaseSensitive, item.Key)]++
+	}
+	if s := ini.getSection(section); s != nil {
+		for _, item := range append([]*iniItem(nil), s.Data...) {
+			if item != nil && count[ident(ini.isCaseSensitive, item.Key)] == 0 {
+				ini.Del(section, item.Key)
+			}
+		}
+	}
+	for _, item := range sec.Items {
+		switch id := ident(ini.isCaseSensitive, item.Key); {
+		case count[id] == 1:
+			ini.Set(section, item.Key, item.Value)
+		case count[id] > 1:
+			// Repeated key, replaced by all its values.
+			ini.Del(section, item.Key)
+			count[id] = -1
+			fallthrough
+		default:
+			ini.Add(section, item.Key, item.Value)
+		}
+		if len(item.Comments) > 0 {
+			ini.SetComments(section, item.Key, item.Comments...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package ini
+
+// MetaData describes the keys used by DecodeMeta.
+type MetaData struct {
+	// Decoded lists the keys decoded into struct fields, in order.
+	Decoded []MetaKey
+	// Undecoded lists the keys no struct field was decoded from.
+	Undecoded []MetaKey
+	// Defaulted lists the fields set to the value of their default tag,
+	// as a path such as Server.TLS.Cert.
+	Defaulted []string
+	// Untouched lists the fields left unchanged, their key being missing.
+	Untouched []string
+}
+
+// MetaKey describes a key of the source.
+type MetaKey struct {
+	Section string
+	Key     string
+	// File and Line locate the key in the source, if known.
+	File string
+	Line int
+	// Field is the path of the struct field the key was decoded into, if any.
+	Field string
+}
+
+// Lookup returns the last key decoded into the field with the given path.
+func (m MetaData) Lookup(field string) (MetaKey, bool) {
+	for i := len(m.Decoded) - 1; i >= 0; i-- {
+		if m.Decoded[i].Field == field {
+			return m.Decoded[i], true
+		}
+	}
+	return MetaKey{}, false
+}
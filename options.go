@@ -1,13 +1,49 @@
 package ini
 
+import (
+	"io/fs"
+	"os"
+)
+
 // Option allows setting various options when creating an Ini type.
 type Option func(*INI) error
 
-// Comment sets the comment character.
+// Comment sets the comment prefixes.
+// The first one is used when writing new comments.
 // It defaults to ";".
-func Comment(prefix string) Option {
+func Comment(prefixes ...string) Option {
+	return func(ini *INI) error {
+		ini.comments = nil
+		for _, prefix := range prefixes {
+			if prefix != "" {
+				ini.comments = append(ini.comments, []byte(prefix))
+			}
+		}
+		return nil
+	}
+}
+
+// Delimiters sets the accepted key/value delimiters.
+// The first delimiter found on a line separates the key from its value.
+// The first one is used when writing new keys.
+// It defaults to "=".
+func Delimiters(delims ...string) Option {
+	return func(ini *INI) error {
+		ini.delimiters = nil
+		for _, d := range delims {
+			if d != "" {
+				ini.delimiters = append(ini.delimiters, []byte(d))
+			}
+		}
+		return nil
+	}
+}
+
+// WriteDelimiter makes WriteTo use the given delimiter for all keys
+// instead of the one they were read with.
+func WriteDelimiter(delim string) Option {
 	return func(ini *INI) error {
-		ini.comment = []byte(prefix)
+		ini.writeDelimiter = delim
 		return nil
 	}
 }
@@ -49,6 +85,233 @@ func MergeSectionsWithLastComments() Option {
 	}
 }
 
+// ContinueOnError makes ReadFrom skip malformed lines instead of stopping
+// at the first one. All the errors are returned once the source is
+// fully read, as ParseErrors.
+func ContinueOnError() Option {
+	return func(ini *INI) error {
+		ini.continueOnError = true
+		return nil
+	}
+}
+
+// Continuation sets the style used for values spanning several lines,
+// both when reading and writing them.
+// It defaults to NoContinuation.
+func Continuation(style ContinuationStyle) Option {
+	return func(ini *INI) error {
+		ini.continuation = style
+		return nil
+	}
+}
+
+// WrapValues splits values so that their lines fit within width characters,
+// when possible, with the BackslashContinuation style.
+// Values are only split on whitespaces.
+func WrapValues(width int) Option {
+	return func(ini *INI) error {
+		ini.wrapWidth = width
+		return nil
+	}
+}
+
+// InlineComments makes ReadFrom strip comments following values on the
+// same line. They must start with the comment prefix after a whitespace,
+// or follow a quoted value.
+// They are available with GetInlineComment.
+func InlineComments() Option {
+	return func(ini *INI) error {
+		ini.inlineComments = true
+		return nil
+	}
+}
+
+// BareKeys allows keys without delimiter nor value, such as MySQL's
+// skip-networking. They are decoded as true into bool fields.
+func BareKeys() Option {
+	return func(ini *INI) error {
+		ini.bareKeys = true
+		return nil
+	}
+}
+
+// MultiValues keeps all the values of repeated keys in a section
+// instead of the last one, as used by systemd units or git config.
+// The values are available with GetAll and are decoded into slices,
+// each value being split on the SliceSeparator.
+func MultiValues() Option {
+	return func(ini *INI) error {
+		ini.multiValues = true
+		return nil
+	}
+}
+
+// PreserveFormatting retains the original text of the lines read by ReadFrom
+// so that WriteTo writes them back unchanged, unless their section or key
+// was modified with Set, SetComments or Del. This keeps the differences
+// minimal when editing hand written files.
+// Sections reopened with the MergeSections option are written back in place,
+// new keys following the last occurrence of their section.
+func PreserveFormatting() Option {
+	return func(ini *INI) error {
+		ini.preserve = true
+		return nil
+	}
+}
+
+// Interpolate expands references to other keys in the values returned by Get
+// and Decode: ${key} refers to a key of the same section or of the global one,
+// ${section.key} to a key of another section and $$ is a literal $.
+// Use GetRaw to get the values as written.
+func Interpolate() Option {
+	return func(ini *INI) error {
+		ini.interpolation |= interpolateBraces
+		return nil
+	}
+}
+
+// PythonInterpolation expands references to other keys written as %(key)s
+// or %(section.key)s, as Python's configparser does, with %% being a literal %.
+// It can be combined with Interpolate.
+func PythonInterpolation() Option {
+	return func(ini *INI) error {
+		ini.interpolation |= interpolatePython
+		return nil
+	}
+}
+
+// ExpandEnv expands environment variables in the values returned by Get
+// and Decode, written as $NAME, ${NAME} or ${ENV:NAME}. ${NAME:-default} uses
+// default if the variable is undefined or empty, and ${NAME:?message} makes
+// it an error. With the Interpolate option, ${NAME} refers to a key and the
+// ENV: prefix is required.
+// The variables are fetched with lookup, or os.LookupEnv if nil.
+func ExpandEnv(lookup func(string) (string, bool)) Option {
+	return func(ini *INI) error {
+		if lookup == nil {
+			lookup = os.LookupEnv
+		}
+		ini.lookupEnv = lookup
+		return nil
+	}
+}
+
+// Includes enables the !include and !includedir directives, as used by MySQL,
+// reading a file or the .ini, .cnf and .conf files of a directory, in
+// lexical order, from fsys. Paths are relative to the directory of the
+// including file and the source given to ReadFrom is at the root of fsys
+// unless its name is a valid path in it.
+//
+// Included files are read in place of the directive and their keys are
+// written by WriteTo, except with PreserveFormatting which writes the
+// directives back instead.
+func Includes(fsys fs.FS) Option {
+	return func(ini *INI) error {
+		ini.fsys = fsys
+		return nil
+	}
+}
+
+// IncludeKey makes the keys with the given name include the file or
+// directory set as their value, in addition to the directives enabled
+// by the Includes option. Without it, they are regular keys.
+func IncludeKey(key string) Option {
+	return func(ini *INI) error {
+		ini.includeKey = key
+		return nil
+	}
+}
+
+// IncludeDepth sets the maximum number of nested includes.
+// It defaults to DefaultIncludeDepth.
+func IncludeDepth(depth int) Option {
+	return func(ini *INI) error {
+		ini.includeDepth = depth
+		return nil
+	}
+}
+
+// DefaultSection makes the keys of the named section the default ones of all
+// the other named sections for Get, Has, Keys and Decode, as the DEFAULT
+// section of Python's configparser. An empty name refers to the global section.
+// The section is not listed by Sections and WriteTo does not copy its keys.
+func DefaultSection(name string) Option {
+	return func(ini *INI) error {
+		ini.hasDefaults = true
+		ini.defaultSection = name
+		return nil
+	}
+}
+
+// SectionInheritance parses section headers such as [child : parent],
+// the child section inheriting the keys of its parent it does not define
+// for Get, Has, Keys and Decode. A parent may inherit from another section.
+// Missing parents and cycles are reported by ReadFrom as a *ParseError.
+func SectionInheritance() Option {
+	return func(ini *INI) error {
+		ini.inheritance = true
+		return nil
+	}
+}
+
+// QuotedSubsections parses git config style section headers such as
+// [remote "origin"], the subsection being quoted and case sensitive.
+// The section is then referred to as remote.origin, using the separator
+// set by SectionSeparator, and its subsections
+// are listed by Subsections. Headers are written as they were read,
+// sections created with a name made of a section and a subsection
+// being written with a quoted subsection.
+// Maps of structs are decoded from all the subsections of the section named
+// after the field, keyed by subsection.
+func QuotedSubsections() Option {
+	return func(ini *INI) error {
+		ini.subsections = true
+		return nil
+	}
+}
+
+// ArraySections parses TOML style [[name]] headers as array sections, which
+// may be repeated, each one holding the keys of an element. They are neither
+// replaced nor merged, Get, Set and Del only reaching the first one.
+// Slices of structs are decoded from all the array sections named after
+// the field, in order, and encoded into them.
+func ArraySections() Option {
+	return func(ini *INI) error {
+		ini.arrays = true
+		return nil
+	}
+}
+
+// SectionSeparator defines the separator used to name the sections of nested
+// structs, such as server.tls for the TLS field of the Server field.
+// It defaults to DefaultSectionSeparator.
+func SectionSeparator(sep string) Option {
+	return func(ini *INI) error {
+		ini.sectionSep = sep
+		return nil
+	}
+}
+
+// OmitDefaults makes Encode leave out the fields holding the value of
+// their default struct tag.
+func OmitDefaults() Option {
+	return func(ini *INI) error {
+		ini.omitDefaults = true
+		return nil
+	}
+}
+
+// DisallowUnknownKeys makes Decode report the keys no struct field is
+// decoded from, along with the missing required keys, as KeyErrors.
+// Keys only referenced by other values with the Interpolate and
+// PythonInterpolation options are not reported.
+func DisallowUnknownKeys() Option {
+	return func(ini *INI) error {
+		ini.strict = true
+		return nil
+	}
+}
+
 // SliceSeparator defines the separator used to split strings when
 // decoding into a slice/map or encoding a slice/map into a key value.
 func SliceSeparator(sep rune) Option {
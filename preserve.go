@@ -0,0 +1,204 @@
+package ini
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// lineWriter writes lines with a given line ending and
+// keeps track of the first error.
+type lineWriter struct {
+	w   io.Writer
+	eol string
+	n   int64
+	err error
+}
+
+func (lw *lineWriter) write(s string) {
+	if lw.err != nil {
+		return
+	}
+	n, err := io.WriteString(lw.w, s)
+	lw.n += int64(n)
+	lw.err = err
+}
+
+func (lw *lineWriter) lines(lines []string) {
+	for _, line := range lines {
+		lw.write(line + lw.eol)
+	}
+}
+
+// print writes the output of fn with the line ending of the lineWriter.
+func (lw *lineWriter) print(fn func(io.Writer) (int, error)) {
+	if lw.err != nil {
+		return
+	}
+	buf := new(bytes.Buffer)
+	if _, err := fn(buf); err != nil {
+		lw.err = err
+		return
+	}
+	s := buf.String()
+	if lw.eol != "\n" {
+		s = strings.Replace(s, "\n", lw.eol, -1)
+	}
+	lw.write(s)
+}
+
+// fragment is the header of a section reopened with the MergeSections option,
+// written back after the section preceding it in the source along with
+// the keys following it.
+type fragment struct {
+	section *iniSection
+	// Number of the fragment, starting at 1.
+	n     int
+	after *iniSection
+	// Original lines preceding the header and its comments and header lines.
+	pre, raw []string
+}
+
+// writePreserved writes the original lines of the unmodified sections and
+// items, and formats the other ones.
+func (ini *INI) writePreserved(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	lw := &lineWriter{w: buf, eol: ini.eol}
+
+	live := map[*iniSection]bool{}
+	for _, section := range ini.sections {
+		live[section] = true
+	}
+	// fragments writes the fragments following the section in the source,
+	// or belonging to it if the one they followed was removed.
+	fragments := func(section *iniSection) {
+		for _, f := range ini.fragments {
+			if live[f.section] && (f.after == section || !live[f.after] && f.section == section) {
+				lw.lines(f.pre)
+				lw.lines(f.raw)
+				ini.writeItemsPreserved(lw, f.section, f.n)
+			}
+		}
+	}
+
+	ini.writeSectionPreserved(lw, &ini.global)
+	trailer := true
+	for _, section := range ini.sections {
+		if section.Included {
+			// Only the keys added to the sections read from included files,
+			// or modified, are written.
+			if !hasOwnItems(section) {
+				continue
+			}
+			if trailer {
+				lw.lines(ini.trailer)
+				trailer = false
+			}
+			if lw.n > 0 {
+				lw.write(lw.eol)
+			}
+		} else if section.Raw == nil {
+			// New section.
+			if trailer {
+				lw.lines(ini.trailer)
+				trailer = false
+			}
+			if lw.n > 0 {
+				lw.write(lw.eol)
+			}
+			lw.print(func(w io.Writer) (int, error) {
+				return ini.printSection(w, section)
+			})
+			continue
+		}
+		ini.writeSectionPreserved(lw, section)
+		fragments(section)
+	}
+	if trailer {
+		lw.lines(ini.trailer)
+	}
+	if lw.err != nil {
+		return 0, lw.err
+	}
+
+	b := buf.Bytes()
+	if ini.noEOL {
+		// The source did not end with a line ending.
+		b = bytes.TrimSuffix(b, []byte(ini.eol))
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (ini *INI) writeSectionPreserved(lw *lineWriter, section *iniSection) {
+	lw.lines(section.Pre)
+	if section.Modified || section.Included {
+		n := lw.n
+		lw.print(func(w io.Writer) (int, error) {
+			return ini.printHeader(w, section)
+		})
+		if section == &ini.global && len(section.Raw) == 0 && lw.n > n {
+			// Newline separating the new global section comments from its keys.
+			lw.write(lw.eol)
+		}
+	} else {
+		lw.lines(section.Raw)
+	}
+	ini.writeItemsPreserved(lw, section, 0)
+}
+
+// writeItemsPreserved writes the items of the given fragment of the section,
+// new items and the Post lines being part of the last one.
+func (ini *INI) writeItemsPreserved(lw *lineWriter, section *iniSection, n int) {
+	// Blank lines are part of the original lines,
+	// unless they follow a new item.
+	var prev *iniItem
+	var post []string
+	if n == section.Fragments {
+		post = section.Post
+	}
+	in := n == 0
+	for _, item := range section.Data {
+		if item == nil {
+			if in && prev != nil && prev.Raw == nil {
+				lw.write(lw.eol)
+			}
+			prev = nil
+			continue
+		}
+		if item.Raw == nil {
+			in = n == section.Fragments
+		} else {
+			in = n == item.Fragment
+		}
+		if !in || item.Included && !item.Modified {
+			continue
+		}
+		if item.Raw == nil {
+			// New items follow the original ones.
+			lw.lines(post)
+			post = nil
+		}
+		lw.lines(item.Pre)
+		if item.Raw == nil || item.Modified {
+			lw.print(func(w io.Writer) (int, error) {
+				return ini.printItem(w, item, len(item.Key))
+			})
+		} else {
+			lw.lines(item.Raw)
+		}
+		prev = item
+	}
+	lw.lines(post)
+}
+
+// hasOwnItems returns whether or not the section has keys
+// that were not read from an included file or were modified.
+func hasOwnItems(section *iniSection) bool {
+	for _, item := range section.Data {
+		if item != nil && (!item.Included || item.Modified) {
+			return true
+		}
+	}
+	return false
+}
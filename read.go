@@ -3,60 +3,181 @@ package ini
 import (
 	"bufio"
 	"bytes"
-	"errors"
-	"fmt"
 	"io"
+	"strconv"
 	"unicode"
 )
 
-var (
-	errInvalidSectionName = errors.New("invalid section name")
-	errInvalidKeyValue    = errors.New("string literal not terminated")
-)
-
 // ReadFrom populates Ini with the data read from the reader.
 // Leading and trailing whitespaces for the key names are removed.
 // Leading whitespaces for key values are removed.
 // If multiple sections have the same name, by default, the last
 // one is used. This can be overridden with the MergeSections option.
+//
+// Malformed lines are reported as a *ParseError, named after the reader
+// if it implements a Name() string method, as *os.File does.
+// With the ContinueOnError option, malformed lines are skipped and
+// all of them are reported at the end as ParseErrors.
+//
+// Values may span several lines according to the Continuation option.
+//
+// With the PreserveFormatting option, the original lines are retained
+// so that WriteTo only rewrites the modified ones.
+//
+// With the Includes option, the lines of included files are read in place
+// of their include directive, as if they were part of the source.
+//
+// With the ArraySections option, [[name]] headers start a new array section
+// each time they are repeated. Otherwise the name of such a section is [name.
 func (ini *INI) ReadFrom(r io.Reader) (int64, error) {
 	var (
 		read int64
-		s    = bufio.NewReader(r)
-		// Current line number.
-		lineNum = 0
+		// Source being read and the ones including it.
+		in    = &includeFile{r: bufio.NewReader(r)}
+		stack []*includeFile
+		// Whether or not to retain the original lines of the source.
+		// Those of included files are not.
+		preserve = ini.preserve
 		// Comments currently parsed.
 		// They are valid for the next element (Section or Item) or global.
 		comments []string
+		// Prefix used by each comment.
+		prefixes []string
 		// Current block and items in the io.Reader.
 		current *iniSection
 		items   []*iniItem
+		// Errors collected with the ContinueOnError option.
+		errs ParseErrors
+		// Item whose value may continue on the next line and
+		// whether it ended with a backslash.
+		last      *iniItem
+		continued bool
+		// Original lines not yet attached to a section or an item,
+		// with the index of the first one holding the current comments.
+		pending      []string
+		commentStart int
+		// Sections with a parent.
+		inherits []inheritance
+		// Whether or not the last line of the source had a line ending.
+		eol = true
 	)
+	if f, ok := r.(interface{ Name() string }); ok {
+		in.name = f.Name()
+	}
+	in.chain = []string{in.name}
+	defer func() {
+		in.close()
+		closeIncludes(stack)
+	}()
+	// attach returns the pending lines preceding the current comments,
+	// and the comments lines followed by the given ones.
+	attach := func(lines ...string) (pre, raw []string) {
+		if !preserve {
+			return nil, nil
+		}
+		if len(comments) == 0 {
+			commentStart = len(pending)
+		}
+		pre = pending[:commentStart:commentStart]
+		raw = append(pending[commentStart:len(pending):len(pending)], lines...)
+		pending = nil
+		return
+	}
 
 	for {
 		// Parse the current line.
-		lineNum++
-		line, err := s.ReadBytes('\n')
-		read += int64(len(line))
+		in.lineNum++
+		line, err := in.r.ReadBytes('\n')
+		if len(stack) == 0 {
+			read += int64(len(line))
+			if len(line) > 0 {
+				eol = line[len(line)-1] == '\n'
+			}
+		}
 		if err != nil {
 			if err != io.EOF {
 				return read, err
 			}
 			// There is potentially data along the io.EOF error.
 			// Ignore the error until there is no more data.
+			if len(line) == 0 && len(stack) > 0 {
+				// Resume reading the including source.
+				in.close()
+				in, stack = stack[len(stack)-1], stack[:len(stack)-1]
+				preserve = ini.preserve && len(stack) == 0
+				last, continued = nil, false
+				continue
+			}
 			if len(line) == 0 {
 				if current == nil {
-					ini.updateSection(items, comments, &ini.global)
+					if len(items) == 0 {
+						ini.global.Pre, ini.global.Raw = attach()
+					}
+					ini.updateSection(items, comments, prefixes, &ini.global)
 				} else {
 					ini.addItemsToSection(items, current)
 				}
+				if ini.preserve {
+					ini.trailer = pending
+					ini.noEOL = !eol
+					if ini.eol == "" {
+						ini.eol = "\n"
+					}
+				}
+				for _, err := range ini.checkInheritance(inherits) {
+					if !ini.continueOnError {
+						return read, err
+					}
+					errs = append(errs, err)
+				}
+				if len(errs) > 0 {
+					return read, errs
+				}
 				return read, nil
 			}
 		}
 		// Remove trailing newline.
-		line = stripNewline(line)
+		text := stripNewline(line)
+		if preserve && ini.eol == "" {
+			ini.eol = string(line[len(text):])
+		}
 		// Ignore leading whitespace for the key name.
-		line = bytes.TrimLeftFunc(line, unicode.IsSpace)
+		line = bytes.TrimLeftFunc(text, unicode.IsSpace)
+		indent := len(text) - len(line)
+		// Errors refer to columns in the original line.
+		// A nil error means the line is to be skipped.
+		parseError := func(kind error, pos int) error {
+			err := &ParseError{in.name, in.lineNum, indent + pos + 1, string(text), kind}
+			if !ini.continueOnError {
+				return err
+			}
+			errs = append(errs, err)
+			if preserve {
+				// Keep the skipped line as is.
+				pending = append(pending, string(text))
+			}
+			return nil
+		}
+
+		if last != nil && preserve && (continued || indent > 0 && len(line) > 0) {
+			last.Raw = append(last.Raw, string(text))
+		}
+		if last != nil {
+			if continued {
+				// The previous line ended with a backslash.
+				last.Value, continued = trimBackslash(last.Value + string(line))
+				if !continued {
+					last = nil
+				}
+				continue
+			}
+			if indent > 0 && len(line) > 0 {
+				// Indented line following a value.
+				last.Value += "\n" + string(line)
+				continue
+			}
+			last = nil
+		}
 
 		if len(line) == 0 {
 			// Empty line is ignored unless used to separate:
@@ -65,46 +186,174 @@ func (ini *INI) ReadFrom(r io.Reader) (int64, error) {
 			if current == nil {
 				// Global section not defined yet.
 				if len(comments) == 0 && len(items) == 0 {
+					if preserve {
+						pending = append(pending, string(text))
+					}
 					continue
 				}
 				current = &ini.global
-				ini.updateSection(items, comments, current)
+				if len(items) == 0 {
+					ini.global.Pre, ini.global.Raw = attach()
+				}
+				ini.updateSection(items, comments, prefixes, current)
 			} else {
 				ini.addItemsToSection(items, current)
 			}
 			items = nil
-			comments = nil
+			comments, prefixes = nil, nil
+			if preserve {
+				pending = append(pending, string(text))
+			}
+			continue
+		}
+
+		// include reads the given file or directory next.
+		// A nil error means reading continues.
+		include := func(name string, dir, stat bool, pos int) error {
+			if preserve {
+				// Keep the directive as is.
+				pending = append(pending, string(text))
+			}
+			// Comments preceding the directive are kept with it.
+			comments, prefixes = nil, nil
+			files, err := ini.openIncludes(in, name, dir, stat)
+			if err != nil {
+				return parseError(err, pos)
+			}
+			if len(files) == 0 {
+				return nil
+			}
+			// Read the files in order, the first one being on top of the stack.
+			stack = append(stack, in)
+			for i := len(files) - 1; i > 0; i-- {
+				stack = append(stack, files[i])
+			}
+			in = files[0]
+			preserve = false
+			last, continued = nil, false
+			return nil
+		}
+
+		if name, dir, pos, ok := ini.includeDirective(line); ok {
+			// Include directive.
+			if err := include(name, dir, false, pos); err != nil {
+				return read, err
+			}
 			continue
 		}
 
 		if line[0] == '[' {
 			// Section.
 			i := bytes.IndexByte(line, ']')
+			var sub []byte
+			if j := bytes.IndexByte(line, '"'); ini.subsections && j > 0 && (i < 0 || j < i) {
+				// Quoted subsection.
+				var rest []byte
+				if sub, rest, err = scanString(line[j:]); err != nil {
+					if err := parseError(err, j); err != nil {
+						return read, err
+					}
+					continue
+				}
+				line = append(append([]byte(nil), bytes.TrimSpace(line[:j])...), bytes.TrimLeftFunc(rest, unicode.IsSpace)...)
+				i = bytes.IndexByte(line, ']')
+			}
+			start := 1
+			array := ini.arrays && sub == nil && bytes.HasPrefix(line, []byte("[["))
+			if array {
+				// Array section, which may be repeated.
+				start, i = 2, bytes.Index(line, []byte("]]"))
+			}
 			if i < 0 {
-				return read, fmt.Errorf("ini: %d: missing ]", lineNum)
+				if err := parseError(ErrMissingBracket, len(line)); err != nil {
+					return read, err
+				}
+				continue
+			}
+			name := string(line[start:i])
+			var parent string
+			var parentErr *ParseError
+			if j := bytes.IndexByte(line[:i], ':'); ini.inheritance && !array && j >= 0 {
+				// Section inheriting keys from its parent.
+				name = string(bytes.TrimSpace(line[1:j]))
+				p := bytes.TrimLeftFunc(line[j+1:i], unicode.IsSpace)
+				parent = string(bytes.TrimRightFunc(p, unicode.IsSpace))
+				pos := i - len(p)
+				parentErr = &ParseError{in.name, in.lineNum, indent + pos + 1, string(text), nil}
 			}
-			name := string(line[1:i])
 			if name == "" {
-				return read, errInvalidSectionName
+				if err := parseError(ErrEmptySectionName, 0); err != nil {
+					return read, err
+				}
+				continue
+			}
+			header := &iniSection{Name: name}
+			if sub != nil {
+				header.Name += ini.sectionSep + string(sub)
+				header.Sub, header.Quoted = string(sub), true
 			}
 
-			if ini.mergeSections == 0 {
-				// Remove any previous section with the same name.
-				ini.rmSection(name)
-			} else if section := ini.getSection(name); section != nil {
-				current = section
-				ini.updateSection(items, comments, current)
-				comments = nil
+			switch section := ini.findSection(header); {
+			case array:
+				// Array sections are neither removed nor merged.
+			case ini.mergeSections == 0:
+				// Remove any previous section with the same header.
+				if section != nil {
+					ini.unlinkSection(section)
+				}
+			case section != nil:
+				switch {
+				case preserve && section.Included:
+					// The section is now part of the source,
+					// the header being kept with the lines of the next element.
+					pending = append(pending, string(text))
+					section.Included = false
+					section.Raw = []string{}
+				case preserve:
+					// The header is written back in place, followed by the keys
+					// read after it.
+					section.Fragments++
+					f := &fragment{section: section, n: section.Fragments, after: ini.sections[len(ini.sections)-1]}
+					f.pre, f.raw = attach(string(text))
+					ini.fragments = append(ini.fragments, f)
+				}
+				// The pending keys belong to the current section, not to
+				// the reopened one.
+				if current == nil {
+					current = &ini.global
+				}
+				ini.addItemsToSection(items, current)
 				items = nil
+				if parent != "" {
+					section.Parent = parent
+					inherits = append(inherits, inheritance{section, parentErr})
+				}
+				current = section
+				ini.updateSection(nil, comments, prefixes, current)
+				comments, prefixes = nil, nil
 				continue
 			}
 
 			section := &iniSection{
 				Comments: comments,
-				Name:     name,
+				Prefixes: prefixes,
+				Name:     header.Name,
+				Sub:      header.Sub,
+				Quoted:   header.Quoted,
+				Parent:   parent,
+				Array:    array,
+				Included: len(stack) > 0,
+			}
+			if parent != "" {
+				inherits = append(inherits, inheritance{section, parentErr})
 			}
-			comments = nil
+			section.Pre, section.Raw = attach(string(text))
+			comments, prefixes = nil, nil
 
+			if current == nil {
+				// Keys preceding the first section.
+				current = &ini.global
+			}
 			ini.addItemsToSection(items, current)
 			items = nil
 
@@ -113,59 +362,130 @@ func (ini *INI) ReadFrom(r io.Reader) (int64, error) {
 			continue
 		}
 
-		if bytes.HasPrefix(line, ini.comment) {
+		if prefix := ini.commentPrefix(line); prefix != nil {
 			// Comment.
-			comments = append(comments, string(line[1:]))
+			if len(comments) == 0 {
+				commentStart = len(pending)
+			}
+			if preserve {
+				pending = append(pending, string(text))
+			}
+			comments = append(comments, string(line[len(prefix):]))
+			prefixes = append(prefixes, string(prefix))
 			continue
 		}
 
 		// Key/Value pair.
-		i := bytes.IndexByte(line, '=')
+		var inlineComment, inlinePrefix []byte
+		i, delim := ini.delimiter(line)
+		noValue := i < 0 && ini.bareKeys
+		if noValue {
+			// Key without value.
+			if ini.inlineComments {
+				line, inlineComment, inlinePrefix = ini.splitComment(line)
+			}
+			i = len(line)
+		}
 		if i < 0 {
-			return read, fmt.Errorf("ini: %d: missing =", lineNum)
+			if err := parseError(ErrMissingEquals, 0); err != nil {
+				return read, err
+			}
+			continue
 		}
 		// Ignore trailing whitespace for the key name.
 		key := string(bytes.TrimRightFunc(line[:i], unicode.IsSpace))
 
 		// Ignore leading whitespace for the value.
-		valueBytes := bytes.TrimLeftFunc(line[i+1:], unicode.IsSpace)
-		valueBytes, err = scanString(valueBytes)
+		valueBytes := bytes.TrimLeftFunc(line[i+len(delim):], unicode.IsSpace)
+		valuePos := len(line) - len(valueBytes)
+		isQuoted := len(valueBytes) > 0 && (valueBytes[0] == '"' || valueBytes[0] == '\'')
+		valueBytes, rest, err := scanString(valueBytes)
 		if err != nil {
-			return read, fmt.Errorf("ini: %d: %v", lineNum, err)
+			if err := parseError(err, valuePos); err != nil {
+				return read, err
+			}
+			continue
+		}
+		if ini.inlineComments && !noValue {
+			if isQuoted {
+				// Only a comment is allowed after a quoted value.
+				_, inlineComment, inlinePrefix = ini.splitComment(bytes.TrimLeftFunc(rest, unicode.IsSpace))
+			} else {
+				valueBytes, inlineComment, inlinePrefix = ini.splitComment(valueBytes)
+			}
 		}
 		value := string(valueBytes)
 
+		if ini.fsys != nil && ini.includeKey != "" && ident(ini.isCaseSensitive, key) == ident(ini.isCaseSensitive, ini.includeKey) {
+			// Include key.
+			if err := include(value, false, true, valuePos); err != nil {
+				return read, err
+			}
+			continue
+		}
+
 		// Deduplicate keys.
-		for i, item := range items {
-			if ident(ini.isCaseSensitive, item.Key) != key {
-				continue
+		if !ini.multiValues {
+			for i, item := range items {
+				if ident(ini.isCaseSensitive, item.Key) != key {
+					continue
+				}
+				n := len(items) - 1
+				copy(items[i:], items[i+1:])
+				items[n] = nil
+				items = items[:n]
 			}
-			n := len(items) - 1
-			copy(items[i:], items[i+1:])
-			items[n] = nil
-			items = items[:n]
 		}
 
 		item := &iniItem{
-			Comments: comments,
-			Key:      key,
-			Value:    value,
+			Comments:      comments,
+			Prefixes:      prefixes,
+			Key:           key,
+			Delimiter:     string(delim),
+			Value:         value,
+			NoValue:       noValue,
+			InlineComment: string(inlineComment),
+			InlinePrefix:  string(inlinePrefix),
+			File:          in.name,
+			Line:          in.lineNum,
+			Included:      len(stack) > 0,
+		}
+		item.Pre, item.Raw = attach(string(text))
+		if preserve && current != nil {
+			item.Fragment = current.Fragments
 		}
-		comments = nil
+		comments, prefixes = nil, nil
 		items = append(items, item)
+
+		if !isQuoted && !noValue {
+			switch ini.continuation {
+			case BackslashContinuation:
+				item.Value, continued = trimBackslash(item.Value)
+				if continued {
+					last = item
+				}
+			case IndentContinuation:
+				last = item
+			}
+		}
 	}
 }
 
-func (ini *INI) updateSection(items []*iniItem, comments []string, section *iniSection) {
+func (ini *INI) updateSection(items []*iniItem, comments, prefixes []string, section *iniSection) {
 	switch ini.mergeSections {
 	case mergeSections:
-		section.Comments = comments
+		section.Comments, section.Prefixes = comments, prefixes
 	case mergeSectionsWithComments:
+		// Comments set by SetComments have no prefix.
+		for len(section.Prefixes) < len(section.Comments) {
+			section.Prefixes = append(section.Prefixes, "")
+		}
 		section.Comments = append(section.Comments, comments...)
+		section.Prefixes = append(section.Prefixes, prefixes...)
 	case mergeSectionsWithLastComments:
-		section.Comments = comments
+		section.Comments, section.Prefixes = comments, prefixes
 	default:
-		section.Comments = comments
+		section.Comments, section.Prefixes = comments, prefixes
 	}
 
 	ini.addItemsToSection(items, section)
@@ -181,7 +501,11 @@ func (ini *INI) addItemsToSection(items []*iniItem, section *iniSection) {
 	}
 
 	// Keys and values.
-	section.Data = dedupItems(section.Data, items, ini.isCaseSensitive)
+	if ini.multiValues {
+		section.Data = append(section.Data, items...)
+	} else {
+		section.Data = dedupItems(section.Data, items, ini.isCaseSensitive)
+	}
 	// Blank line.
 	section.Data = append(section.Data, nil)
 }
@@ -211,43 +535,131 @@ func dedupItems(a, b []*iniItem, flag bool) []*iniItem {
 }
 
 // scanString scans a string and handles quoted ones.
-func scanString(buf []byte) ([]byte, error) {
+// Double quoted strings may contain the escape sequences \n, \t, \r,
+// \\, \", \xNN and \uXXXX. Single quoted strings are taken literally.
+// The remaining bytes after a quoted string are returned in rest.
+func scanString(buf []byte) (value, rest []byte, err error) {
 	n := len(buf)
 	if n == 0 || n == 1 {
-		return buf, nil
+		return buf, nil, nil
 	}
 	// Is the string quoted?
-	quote := buf[0]
-	if quote != '"' && quote != '\'' {
+	switch buf[0] {
+	case '\'':
+		i := bytes.IndexByte(buf[1:], '\'')
+		if i < 0 {
+			return nil, nil, ErrUnterminatedQuote
+		}
+		return buf[1 : i+1], buf[i+2:], nil
+	case '"':
+	default:
 		// Not quoted.
-		return buf, nil
+		return buf, nil, nil
 	}
 
-	// Quoted.
-	var escapers []int
-	idx := 1
-	for buf[idx] != quote {
-		if c := buf[idx]; c == '\\' {
-			escapers = append(escapers, idx)
-			idx++
+	// Double quoted.
+	value = make([]byte, 0, n)
+	for idx := 1; idx < n; idx++ {
+		switch c := buf[idx]; c {
+		case '"':
+			return value, buf[idx+1:], nil
+		case '\\':
+		default:
+			value = append(value, c)
+			continue
 		}
-		idx++
-		if idx == n {
-			return nil, errInvalidKeyValue
+		// Escape sequence.
+		if idx++; idx == n {
+			break
+		}
+		switch c := buf[idx]; c {
+		case 'n':
+			value = append(value, '\n')
+		case 't':
+			value = append(value, '\t')
+		case 'r':
+			value = append(value, '\r')
+		case 'x':
+			if v, ok := unhex(buf[idx+1:], 2); ok {
+				value = append(value, byte(v))
+				idx += 2
+			} else {
+				value = append(value, c)
+			}
+		case 'u':
+			if v, ok := unhex(buf[idx+1:], 4); ok {
+				value = append(value, string(rune(v))...)
+				idx += 4
+			} else {
+				value = append(value, c)
+			}
+		default:
+			// Including \\ and \".
+			value = append(value, c)
 		}
 	}
-	buf = buf[1:idx]
+	return nil, nil, ErrUnterminatedQuote
+}
 
-	if len(escapers) == 0 {
-		return buf, nil
+// unhex decodes the first size bytes of buf as an hexadecimal number.
+func unhex(buf []byte, size int) (uint64, bool) {
+	if len(buf) < size {
+		return 0, false
 	}
+	v, err := strconv.ParseUint(string(buf[:size]), 16, 32)
+	return v, err == nil
+}
 
-	// Remove escapers.
-	for i, pos := range escapers {
-		copy(buf[pos-i-1:], buf[pos-i:])
+// delimiter returns the position of the first key/value delimiter in buf
+// or -1 if there is none.
+// The longest delimiter is used if several start at the same position.
+func (ini *INI) delimiter(buf []byte) (int, []byte) {
+	idx, delim := -1, []byte(nil)
+	for _, d := range ini.delimiters {
+		i := bytes.Index(buf, d)
+		if i < 0 {
+			continue
+		}
+		if idx < 0 || i < idx || (i == idx && len(d) > len(delim)) {
+			idx, delim = i, d
+		}
+	}
+	return idx, delim
+}
+
+// commentPrefix returns the longest comment prefix buf starts with, if any.
+func (ini *INI) commentPrefix(buf []byte) []byte {
+	var prefix []byte
+	for _, p := range ini.comments {
+		if len(p) > len(prefix) && bytes.HasPrefix(buf, p) {
+			prefix = p
+		}
+	}
+	return prefix
+}
+
+// splitComment returns the inline comment starting with a comment prefix
+// after a whitespace, if any, and the text before it.
+func (ini *INI) splitComment(buf []byte) (text, comment, prefix []byte) {
+	for i := 0; i < len(buf); i++ {
+		if i > 0 && !unicode.IsSpace(rune(buf[i-1])) {
+			continue
+		}
+		if prefix := ini.commentPrefix(buf[i:]); prefix != nil {
+			text = bytes.TrimRightFunc(buf[:i], unicode.IsSpace)
+			return text, buf[i+len(prefix):], prefix
+		}
 	}
+	return buf, nil, nil
+}
 
-	return buf[:len(buf)-len(escapers)], nil
+// trimBackslash removes the trailing backslash of a value continued
+// on the next line.
+func trimBackslash(s string) (string, bool) {
+	if n := len(s); n > 0 && s[n-1] == '\\' {
+		return s[:n-1], true
+	}
+	return s, false
 }
 
 // buf may end with \n or \r\n.
@@ -5,11 +5,37 @@ package ini
 // The Section may contain identical keys.
 type iniSection struct {
 	Comments []string
+	// Prefixes holds the comment prefix used by each comment, if known.
+	Prefixes []string
 	Name     string
+	// Sub is the subsection of headers such as [remote "origin"], the name
+	// then being the section and subsection joined by the SectionSeparator.
+	// Quoted is set for them.
+	Sub    string
+	Quoted bool
+	// Parent is the name of the section keys are inherited from.
+	Parent string
+	// Array is set for sections defined as [[name]],
+	// which may be repeated.
+	Array bool
+	// Included is set for sections read from an included file.
+	Included bool
+	// Fragments is the number of times the section was reopened
+	// with the PreserveFormatting and MergeSections options.
+	Fragments int
 
 	// Keys may be grouped together and separated by a blank line.
 	// A blank line is represented by a nil *Item.
 	Data []*iniItem
+
+	// With the PreserveFormatting option, Pre holds the original lines
+	// preceding the section and Raw its comments and header lines.
+	// Modified is set once they no longer match the section.
+	// Post holds the lines preceding removed items that were last.
+	Pre      []string
+	Raw      []string
+	Post     []string
+	Modified bool
 }
 
 // flag indicates whether or not the search is case sensitive.
@@ -20,6 +46,7 @@ func (s *iniSection) get(key string, flag bool) *string {
 	return nil
 }
 
+// getItem returns the last item for the key.
 // flag indicates whether or not the search is case sensitive.
 func (s *iniSection) getItem(key string, flag bool) *iniItem {
 	if s == nil {
@@ -27,7 +54,8 @@ func (s *iniSection) getItem(key string, flag bool) *iniItem {
 	}
 	key = ident(flag, key)
 
-	for _, item := range s.Data {
+	for i := len(s.Data) - 1; i >= 0; i-- {
+		item := s.Data[i]
 		if item == nil {
 			continue
 		}
@@ -38,6 +66,26 @@ func (s *iniSection) getItem(key string, flag bool) *iniItem {
 	return nil
 }
 
+// getAll returns all the items for the key in order.
+// flag indicates whether or not the search is case sensitive.
+func (s *iniSection) getAll(key string, flag bool) []*iniItem {
+	if s == nil {
+		return nil
+	}
+	key = ident(flag, key)
+
+	var items []*iniItem
+	for _, item := range s.Data {
+		if item == nil {
+			continue
+		}
+		if ident(flag, item.Key) == key {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // flag indicates whether or not the search is case sensitive.
 func (s *iniSection) rmItem(key string, flag bool) bool {
 	if s == nil {
@@ -49,6 +97,20 @@ func (s *iniSection) rmItem(key string, flag bool) bool {
 			continue
 		}
 		if ident(flag, item.Key) == key {
+			if item.Pre != nil {
+				// Keep the preceding lines with the next item or the section.
+				var next *iniItem
+				for _, next = range s.Data[i+1:] {
+					if next != nil {
+						break
+					}
+				}
+				if next != nil {
+					next.Pre = append(item.Pre, next.Pre...)
+				} else {
+					s.Post = append(item.Pre, s.Post...)
+				}
+			}
 			n := len(s.Data) - 1
 			skip := 1
 			// Remove newline if the key was the last one in the block.
@@ -69,9 +131,30 @@ func (s *iniSection) rmItem(key string, flag bool) bool {
 }
 
 // iniItem represents a key/value pair.
-// It may have comments.
+// It may have comments, including one on the same line.
 type iniItem struct {
-	Comments []string
-	Key      string
-	Value    string
+	Comments  []string
+	Prefixes  []string
+	Key       string
+	Delimiter string
+	Value     string
+	// NoValue is set for keys without delimiter nor value.
+	NoValue       bool
+	InlineComment string
+	InlinePrefix  string
+	// File and Line locate the key in the source it was read from.
+	// Included is set for keys read from an included file.
+	File     string
+	Line     int
+	Included bool
+	// Fragment is the number of the reopened header of the section the
+	// item follows with the PreserveFormatting and MergeSections options.
+	Fragment int
+
+	// With the PreserveFormatting option, Pre holds the original lines
+	// preceding the item and Raw its comments and key/value lines.
+	// Modified is set once they no longer match the item.
+	Pre      []string
+	Raw      []string
+	Modified bool
 }
@@ -0,0 +1,127 @@
+package ini
+
+import (
+	"strings"
+)
+
+// baseName returns the name of the section without its quoted subsection.
+func (ini *INI) baseName(s *iniSection) string {
+	if !s.Quoted {
+		return s.Name
+	}
+	return s.Name[:len(s.Name)-len(ini.sectionSep)-len(s.Sub)]
+}
+
+// sectionIs returns whether or not the section has the given name,
+// its quoted subsection being case sensitive.
+func (ini *INI) sectionIs(s *iniSection, name string) bool {
+	if !s.Quoted {
+		return ident(ini.isCaseSensitive, s.Name) == ident(ini.isCaseSensitive, name)
+	}
+	suffix := ini.sectionSep + s.Sub
+	return strings.HasSuffix(name, suffix) &&
+		ident(ini.isCaseSensitive, strings.TrimSuffix(name, suffix)) == ident(ini.isCaseSensitive, ini.baseName(s))
+}
+
+// sectionID returns the identifier of the section as defined by its header,
+// which tells apart [a.b "c"] from [a "b.c"].
+func (ini *INI) sectionID(s *iniSection) string {
+	id := ident(ini.isCaseSensitive, ini.baseName(s))
+	if s.Quoted {
+		id += "\x00" + s.Sub
+	}
+	return id
+}
+
+// findSection returns the section with the same header as s, if any.
+func (ini *INI) findSection(s *iniSection) *iniSection {
+	id := ini.sectionID(s)
+	for _, sec := range ini.sections {
+		if !sec.Array && ini.sectionID(sec) == id {
+			return sec
+		}
+	}
+	return nil
+}
+
+// newSection returns a section with the given name, sections named with
+// a subsection having it quoted with the QuotedSubsections option.
+func (ini *INI) newSection(name string) *iniSection {
+	s := &iniSection{Name: name}
+	if i := strings.Index(name, ini.sectionSep); ini.subsections && i >= 0 {
+		s.Sub, s.Quoted = name[i+len(ini.sectionSep):], true
+	}
+	return s
+}
+
+// subsectionOf returns the first level of the name of s nested in the given
+// section, the quoted subsection being a single level.
+func (ini *INI) subsectionOf(s *iniSection, section string) (string, bool) {
+	base := ini.baseName(s)
+	if s.Quoted && ident(ini.isCaseSensitive, base) == ident(ini.isCaseSensitive, section) {
+		return s.Sub, true
+	}
+	prefix := ident(ini.isCaseSensitive, section) + ini.sectionSep
+	if !strings.HasPrefix(ident(ini.isCaseSensitive, base), prefix) {
+		return "", false
+	}
+	sub := base[len(prefix):]
+	if i := strings.Index(sub, ini.sectionSep); i >= 0 {
+		sub = sub[:i]
+	}
+	return sub, true
+}
+
+// Subsections returns the names of the sections nested in the given one,
+// such as a for [backend.a], or origin for [remote "origin"] referred to as
+// remote.origin with the QuotedSubsections option, in the order they are
+// defined. Array sections are excluded.
+func (ini *INI) Subsections(section string) []string {
+	var subs []string
+next:
+	for _, s := range ini.sections {
+		if s.Array {
+			continue
+		}
+		sub, ok := ini.subsectionOf(s, section)
+		if !ok {
+			continue
+		}
+		for _, name := range subs {
+			if name == sub {
+				continue next
+			}
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// headerName returns the name of the section as written in its header,
+// with its subsection quoted if it was.
+func (ini *INI) headerName(s *iniSection) string {
+	if !s.Quoted {
+		return s.Name
+	}
+	sub := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s.Sub)
+	return ini.baseName(s) + ` "` + sub + `"`
+}
+
+// nestedSection returns the name of the section nested in the given one.
+func (ini *INI) nestedSection(section, name string) string {
+	if section == "" {
+		return name
+	}
+	return section + ini.sectionSep + name
+}
+
+// hasNestedSections returns whether or not the section or
+// any section nested in it exists.
+func (ini *INI) hasNestedSections(section string) bool {
+	for _, s := range ini.sections {
+		if _, ok := ini.subsectionOf(s, section); ok || ini.sectionIs(s, section) {
+			return true
+		}
+	}
+	return false
+}
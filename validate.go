@@ -0,0 +1,284 @@
+package ini
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pierrec/go-ini/internal/structs"
+)
+
+// validateTagID is the struct tag listing the rules a field value must satisfy.
+const validateTagID = "validate"
+
+// Rules supported in the validate struct tag.
+var validateRules = []string{"min=", "max=", "oneof=", "match=", "nonempty"}
+
+// Validator is implemented by structs checking their values once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// validation records the rules failed by the decoded structs and
+// their Validate methods to be called, nested ones first.
+type validation struct {
+	rules      RuleErrors
+	validators []validator
+}
+
+type validator struct {
+	section string
+	v       Validator
+}
+
+// validateAll checks the rules of the validate struct tags of v, then calls
+// the Validate methods of its structs if all are satisfied.
+func (ini *INI) validateAll(v interface{}) error {
+	var vs validation
+	if err := ini.validate(&vs, "", "", v, false); err != nil {
+		return err
+	}
+	if len(vs.rules) > 0 {
+		return vs.rules
+	}
+	for _, val := range vs.validators {
+		if err := val.v.Validate(); err != nil {
+			return &ValidationError{val.section, err}
+		}
+	}
+	return nil
+}
+
+// validate walks v, a pointer to a struct decoded from the section and found
+// at the given field path. embedded is set when v is an embedded struct,
+// whose own embedded types are ignored.
+func (ini *INI) validate(vs *validation, section, path string, v interface{}, embedded bool) error {
+	root, err := structs.NewStruct(v, iniTagID)
+	if err != nil {
+		return err
+	}
+	for _, field := range root.Fields() {
+		fsection, key, _ := getTagInfo(field.Tag(), field.Name())
+		if fsection == "" {
+			fsection = section
+		}
+		fpath := field.FieldName()
+		if path != "" {
+			fpath = path + "." + fpath
+		}
+
+		if emb := field.Embedded(); emb != nil {
+			if embedded {
+				continue
+			}
+			if fsection == "" {
+				// Top level embedded struct, as decoded.
+				fsection = field.Name()
+			}
+			if err := ini.validate(vs, fsection, path, emb, true); err != nil {
+				return err
+			}
+			if val, ok := field.PtrValue().(Validator); ok {
+				vs.validators = append(vs.validators, validator{fsection, val})
+			}
+			continue
+		}
+
+		var err error
+		switch nested := ini.nestedSection(fsection, key); {
+		case isSectionType(reflect.TypeOf(field.Value()), sectionUnmarshalType):
+			err = ini.validateElem(vs, nested, fpath, reflect.ValueOf(field.PtrValue()).Elem())
+		case isSectionMap(field):
+			m := reflect.ValueOf(field.Value())
+			keys := m.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, k := range keys {
+				p := fmt.Sprintf("%s[%s]", fpath, k)
+				if err = ini.validateElem(vs, nested+ini.sectionSep+k.String(), p, m.MapIndex(k)); err != nil {
+					break
+				}
+			}
+		case isSectionSlice(field):
+			s := reflect.ValueOf(field.Value())
+			for i := 0; i < s.Len() && err == nil; i++ {
+				p := fmt.Sprintf("%s[%d]", fpath, i)
+				err = ini.validateElem(vs, fmt.Sprintf("%s[%d]", nested, i), p, s.Index(i))
+			}
+		default:
+			err = vs.checkRules(fsection, key, fpath, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if val, ok := v.(Validator); ok && declares(v, "Validate") {
+		// Promoted methods are called with their embedded struct.
+		vs.validators = append(vs.validators, validator{section, val})
+	}
+	return nil
+}
+
+// declares returns whether or not the type of v, or the one it points to,
+// declares the method itself instead of having it promoted from an embedded
+// field, whose method is wrapped by a compiler generated one.
+func declares(v interface{}, name string) bool {
+	t := reflect.TypeOf(v)
+	for _, t := range []reflect.Type{t, t.Elem()} {
+		if m, ok := t.MethodByName(name); ok {
+			pc := m.Func.Pointer()
+			if file, _ := runtime.FuncForPC(pc).FileLine(pc); file != "<autogenerated>" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateElem validates the struct, or pointer to struct, v.
+func (ini *INI) validateElem(vs *validation, section, path string, v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+	case v.CanAddr():
+		v = v.Addr()
+	default:
+		// Map values cannot be addressed.
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p
+	}
+	if _, ok := v.Interface().(SectionUnmarshaler); ok {
+		// Not decoded from its fields.
+		if val, ok := v.Interface().(Validator); ok {
+			vs.validators = append(vs.validators, validator{section, val})
+		}
+		return nil
+	}
+	return ini.validate(vs, section, path, v.Interface(), false)
+}
+
+// checkRules records the rules of the validate tag the field does not satisfy.
+// Invalid rules are reported as errors.
+func (vs *validation) checkRules(section, key, path string, field *structs.StructField) error {
+	tag, ok := field.Tag().Lookup(validateTagID)
+	if !ok {
+		return nil
+	}
+	v := reflect.ValueOf(field.Value())
+	for _, rule := range splitRules(tag) {
+		ok, err := checkRule(rule, v)
+		if err != nil {
+			return fmt.Errorf("ini: decode: %s: invalid rule %q: %v", path, rule, err)
+		}
+		if !ok {
+			vs.rules = append(vs.rules, &RuleError{section, key, path, rule})
+		}
+	}
+	return nil
+}
+
+// splitRules splits the validate tag into its rules, the commas
+// not followed by a rule name being part of the previous one.
+func splitRules(tag string) []string {
+	var rules []string
+	for _, s := range strings.Split(tag, ",") {
+		if len(rules) > 0 && !isRule(s) {
+			rules[len(rules)-1] += "," + s
+			continue
+		}
+		rules = append(rules, s)
+	}
+	return rules
+}
+
+func isRule(s string) bool {
+	for _, r := range validateRules {
+		if s == r || strings.HasSuffix(r, "=") && strings.HasPrefix(s, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRule returns whether or not the value satisfies the rule.
+// Nil pointers only fail the nonempty rule.
+func checkRule(rule string, v reflect.Value) (bool, error) {
+	name, arg := rule, ""
+	if i := strings.IndexByte(rule, '='); i > 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+	if name == "nonempty" {
+		return !isEmpty(v), nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return true, nil
+		}
+		v = v.Elem()
+	}
+
+	switch name {
+	case "min", "max":
+		n, bound, err := ruleNumbers(v, arg)
+		if err != nil {
+			return false, err
+		}
+		if name == "min" {
+			return n >= bound, nil
+		}
+		return n <= bound, nil
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, opt := range strings.Split(arg, "|") {
+			if s == opt {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "match":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(fmt.Sprint(v.Interface())), nil
+	}
+	return false, fmt.Errorf("unknown rule %s", name)
+}
+
+// ruleNumbers returns the number compared by the min and max rules,
+// which is the length of strings, slices and maps, along with the bound.
+func ruleNumbers(v reflect.Value, bound string) (n, b float64, err error) {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(bound)
+		return float64(v.Int()), float64(d), err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		n = float64(v.Len())
+	default:
+		return 0, 0, fmt.Errorf("not supported for %s", v.Type())
+	}
+	b, err = strconv.ParseFloat(bound, 64)
+	return
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
@@ -3,10 +3,17 @@ package ini
 import (
 	"fmt"
 	"io"
+	"strings"
+	"unicode"
 )
 
 // WriteTo writes the contents of Ini to the given Writer.
+// With the PreserveFormatting option, the lines read by ReadFrom are written
+// back unchanged unless their section or key was modified.
 func (ini *INI) WriteTo(w io.Writer) (int64, error) {
+	if ini.preserve && ini.eol != "" {
+		return ini.writePreserved(w)
+	}
 	var written int64
 
 	// Global section.
@@ -35,10 +42,15 @@ func (ini *INI) WriteTo(w io.Writer) (int64, error) {
 	return written, nil
 }
 
-func (ini *INI) printComments(w io.Writer, comments []string) (int, error) {
+// printComments writes the comments with their prefix, if known.
+func (ini *INI) printComments(w io.Writer, comments, prefixes []string) (int, error) {
 	var written int
-	for _, s := range comments {
-		n, err := fmt.Fprintf(w, "%s%s\n", ini.comment, s)
+	for i, s := range comments {
+		var prefix string
+		if i < len(prefixes) {
+			prefix = prefixes[i]
+		}
+		n, err := fmt.Fprintf(w, "%s%s\n", ini.commentOr(prefix), s)
 		written += n
 		if err != nil {
 			return written, err
@@ -47,10 +59,11 @@ func (ini *INI) printComments(w io.Writer, comments []string) (int, error) {
 	return written, nil
 }
 
-func (ini *INI) printSection(w io.Writer, section *iniSection) (int, error) {
+// printHeader writes the section comments and name.
+func (ini *INI) printHeader(w io.Writer, section *iniSection) (int, error) {
 	var written int
 
-	n, err := ini.printComments(w, section.Comments)
+	n, err := ini.printComments(w, section.Comments, section.Prefixes)
 	written += n
 	if err != nil {
 		return written, err
@@ -58,12 +71,29 @@ func (ini *INI) printSection(w io.Writer, section *iniSection) (int, error) {
 
 	isGlobal := section.Name == ""
 	if !isGlobal {
-		n, err := fmt.Fprintf(w, "[%s]\n", section.Name)
+		name := ini.headerName(section)
+		if section.Parent != "" {
+			name += " : " + section.Parent
+		}
+		if section.Array {
+			name = "[" + name + "]"
+		}
+		n, err := fmt.Fprintf(w, "[%s]\n", name)
 		written += n
 		if err != nil {
 			return written, err
 		}
-	} else if n > 0 {
+	}
+	return written, nil
+}
+
+func (ini *INI) printSection(w io.Writer, section *iniSection) (int, error) {
+	written, err := ini.printHeader(w, section)
+	if err != nil {
+		return written, err
+	}
+
+	if section.Name == "" && written > 0 {
 		// Newline separating the global section comments from its keys.
 		n, err := fmt.Fprintf(w, "\n")
 		written += n
@@ -93,20 +123,14 @@ func (ini *INI) printSection(w io.Writer, section *iniSection) (int, error) {
 		var n int
 		for _, item := range block {
 			k := item.Key
-			if len(k) > n {
+			if !item.NoValue && len(k) > n {
 				n = len(k)
 			}
 		}
-		kvFmt := fmt.Sprintf("%%-%ds = %%s\n", n)
 
-		// Print all items with the equal sign aligned for all keys of this block.
+		// Print all items with the delimiter aligned for all keys of this block.
 		for _, item := range block {
-			n, err := ini.printComments(w, item.Comments)
-			written += n
-			if err != nil {
-				return written, err
-			}
-			n, err = fmt.Fprintf(w, kvFmt, item.Key, item.Value)
+			n, err := ini.printItem(w, item, n)
 			written += n
 			if err != nil {
 				return written, err
@@ -125,3 +149,146 @@ func (ini *INI) printSection(w io.Writer, section *iniSection) (int, error) {
 
 	return written, nil
 }
+
+// printItem writes the item comments and key/value pair, with the key
+// padded to width characters.
+func (ini *INI) printItem(w io.Writer, item *iniItem, width int) (int, error) {
+	written, err := ini.printComments(w, item.Comments, item.Prefixes)
+	if err != nil {
+		return written, err
+	}
+
+	delim := ini.delimiterOf(item)
+	// Values start after the key and the delimiter.
+	pad := width + len(delim) + 2
+
+	value, ok := ini.quoteValue(item.Value)
+	if !ok {
+		value = ini.formatValue(item.Value, pad)
+	}
+	if item.InlineComment != "" {
+		if value != "" {
+			value += " "
+		}
+		value += ini.commentOr(item.InlinePrefix) + item.InlineComment
+	}
+
+	var n int
+	if item.NoValue {
+		if value != "" {
+			value = " " + value
+		}
+		n, err = fmt.Fprintf(w, "%s%s\n", item.Key, value)
+	} else {
+		n, err = fmt.Fprintf(w, "%-*s %s %s\n", width, item.Key, delim, value)
+	}
+	return written + n, err
+}
+
+// delimiterOf returns the delimiter to be written for the item.
+func (ini *INI) delimiterOf(item *iniItem) string {
+	switch {
+	case ini.writeDelimiter != "":
+		return ini.writeDelimiter
+	case item.Delimiter != "":
+		return item.Delimiter
+	}
+	return string(ini.delimiters[0])
+}
+
+// commentOr returns the prefix or the default comment prefix if it is empty.
+func (ini *INI) commentOr(prefix string) string {
+	if prefix == "" {
+		return string(ini.comments[0])
+	}
+	return prefix
+}
+
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// quoteValue returns the value double quoted and escaped if it could not be
+// read back as is otherwise.
+func (ini *INI) quoteValue(value string) (string, bool) {
+	if !ini.needsQuotes(value) {
+		return value, false
+	}
+	return `"` + escaper.Replace(value) + `"`, true
+}
+
+func (ini *INI) needsQuotes(value string) bool {
+	n := len(value)
+	if n == 0 {
+		return false
+	}
+	switch {
+	case value[0] == '"', value[0] == '\'':
+		return true
+	case unicode.IsSpace(rune(value[0])), unicode.IsSpace(rune(value[n-1])):
+		return true
+	case strings.ContainsRune(value, '\r'):
+		return true
+	case strings.ContainsRune(value, '\n'):
+		if ini.continuation != IndentContinuation {
+			return true
+		}
+		// Newlines are written as continuation lines with this style,
+		// which cannot be empty nor start with whitespaces.
+		for _, line := range strings.Split(value, "\n")[1:] {
+			if line == "" || unicode.IsSpace(rune(line[0])) {
+				return true
+			}
+		}
+		return false
+	case ini.continuation == BackslashContinuation && value[n-1] == '\\':
+		return true
+	case ini.inlineComments:
+		_, _, prefix := ini.splitComment([]byte(value))
+		return prefix != nil
+	}
+	return false
+}
+
+// formatValue splits the value over several lines according to
+// the continuation style. Continuation lines are indented with pad spaces.
+func (ini *INI) formatValue(value string, pad int) string {
+	indent := "\n" + strings.Repeat(" ", pad)
+	switch ini.continuation {
+	case IndentContinuation:
+		return strings.Replace(value, "\n", indent, -1)
+	case BackslashContinuation:
+		if ini.wrapWidth <= 0 || pad+len(value) <= ini.wrapWidth {
+			return value
+		}
+	default:
+		return value
+	}
+
+	// Split the value after whitespaces so that they are kept
+	// at the end of the line, before the backslash.
+	var res []string
+	var line string
+	for value != "" {
+		i := strings.IndexByte(value, ' ')
+		if i < 0 {
+			i = len(value)
+		}
+		for i < len(value) && value[i] == ' ' {
+			i++
+		}
+		word := value[:i]
+		value = value[i:]
+		if line != "" && pad+len(line)+len(word)+1 > ini.wrapWidth {
+			res = append(res, line)
+			line = ""
+		}
+		line += word
+	}
+	res = append(res, line)
+	return strings.Join(res, "\\"+indent)
+}